@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/shopspring/decimal"
+)
+
+// SubmitBatch evaluates orders one after another, each against the state left by the previous ones,
+// and returns a single transaction holding all of them, nothing is applied if any order fails.
+// The book is left as it was, Commit applies the orders again and fails with ErrBatchDiverged
+// if they do not do the same. All orders are stamped with the time of the batch.
+// Stop orders are triggered once the whole batch has been committed
+func (ob *OrderBook) SubmitBatch(orders []*Order) (Transaction, error) {
+	now := ob.clock.Now()
+	evaluated, revert, err := ob.applyBatch(orders, now)
+	if err != nil {
+		return Transaction{}, err
+	}
+	revert()
+
+	batch := ob.newTransaction(evaluated.orders, evaluated.trades, func() {
+		ob.stopRecording()
+	})
+	batch.prevented = evaluated.prevented
+	batch.cancelled = evaluated.cancelled
+	batch.prepare = func() error {
+		applied, revert, err := ob.applyBatch(orders, now)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(applied, evaluated) {
+			revert()
+			return ErrBatchDiverged
+		}
+		return nil
+	}
+	return batch, nil
+}
+
+type batchResult struct {
+	orders    []*Order
+	trades    []Trade
+	prevented []SelfTrade
+	cancelled []Cancellation
+}
+
+// applyBatch submits and finalizes orders with the clock frozen at now, changed price levels are recorded
+// until revert or stopRecording is called. A failed batch is reverted already
+func (ob *OrderBook) applyBatch(orders []*Order, now MillisecondTimestamp) (batchResult, func(), error) {
+	containers := []*OrderContainer{ob.buy, ob.sell, ob.buyStops, ob.sellStops}
+	for _, oc := range containers {
+		oc.undo = newContainerUndo(oc)
+	}
+	submitted := make([]Order, len(orders))
+	for i, order := range orders {
+		submitted[i] = *order
+	}
+
+	revert := func() {
+		for _, oc := range containers {
+			oc.revert()
+		}
+		for i, order := range orders {
+			*order = submitted[i]
+		}
+	}
+
+	clock := ob.clock
+	ob.clock = NewManualClock(now)
+	defer func() {
+		ob.clock = clock
+	}()
+
+	var r batchResult
+	for _, order := range orders {
+		tr, err := ob.SubmitOrder(order)
+		if err != nil {
+			revert()
+			return batchResult{}, nil, err
+		}
+		if tr.finalize != nil {
+			tr.finalize()
+		}
+
+		r.orders = append(r.orders, tr.orders...)
+		r.trades = append(r.trades, tr.trades...)
+		r.prevented = append(r.prevented, tr.prevented...)
+		r.cancelled = append(r.cancelled, tr.cancelled...)
+	}
+	return r, revert, nil
+}
+
+func (ob *OrderBook) stopRecording() {
+	for _, oc := range []*OrderContainer{ob.buy, ob.sell, ob.buyStops, ob.sellStops} {
+		oc.undo = nil
+	}
+}
+
+// containerUndo keeps container state from before a batch, price levels are saved on their first change
+type containerUndo struct {
+	levels  map[priceKey]levelUndo
+	volume  decimal.Decimal
+	visible decimal.Decimal
+}
+
+type levelUndo struct {
+	price   decimal.Decimal
+	existed bool
+	orders  []restingOrder // in queue priority
+}
+
+type restingOrder struct {
+	order *Order
+	saved Order
+}
+
+func newContainerUndo(oc *OrderContainer) *containerUndo {
+	return &containerUndo{
+		levels:  make(map[priceKey]levelUndo),
+		volume:  oc.volume,
+		visible: oc.visible,
+	}
+}
+
+// record saves the price level before its first change in a batch
+func (oc *OrderContainer) record(price decimal.Decimal) {
+	if oc.undo == nil {
+		return
+	}
+
+	key := price.String()
+	if _, ok := oc.undo.levels[key]; ok {
+		return
+	}
+
+	l := levelUndo{price: price}
+	if queue, ok := oc.priceHash[key]; ok {
+		l.existed = true
+		l.orders = make([]restingOrder, 0, queue.orders.Len())
+		for el := queue.orders.Front(); el != nil; el = el.Next() {
+			order := el.Value.(*Order)
+			l.orders = append(l.orders, restingOrder{order: order, saved: *order})
+		}
+	}
+	oc.undo.levels[key] = l
+}
+
+// revert rebuilds recorded price levels as they have been before the batch and stops recording
+func (oc *OrderContainer) revert() {
+	if oc.undo == nil {
+		return
+	}
+
+	for key, l := range oc.undo.levels {
+		if queue, ok := oc.priceHash[key]; ok {
+			for el := queue.orders.Front(); el != nil; el = el.Next() {
+				oc.unindex(el.Value.(*Order).ID)
+			}
+			delete(oc.priceHash, key)
+			oc.priceTree.Remove(queue.Price())
+		}
+		if !l.existed {
+			continue
+		}
+
+		// orders are put back as they were, queue.Add would show a fresh iceberg tip
+		queue := newOrderQueue(l.price)
+		for _, r := range l.orders {
+			*r.order = r.saved
+			el := queue.orders.PushBack(r.order)
+			queue.volume = queue.volume.Add(r.order.Amount)
+			queue.visible = queue.visible.Add(r.order.visible())
+
+			oc.index[r.order.ID] = el
+			if r.order.expirable() {
+				oc.expiring[r.order.ID] = el
+			}
+		}
+		oc.priceHash[key] = queue
+		oc.priceTree.Put(l.price, queue)
+	}
+
+	oc.volume = oc.undo.volume
+	oc.visible = oc.undo.visible
+	oc.undo = nil
+}
+
+var (
+	ErrBatchDiverged = errors.New("batch does not apply as evaluated")
+)
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubmitBatch(t *testing.T) {
+	init := func(t *testing.T) *OrderBook {
+		ob := NewOrderBook()
+		orders := []Order{
+			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 2, Price: decimal.NewFromFloat(9.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+		}
+		for _, v := range orders {
+			submitOrder(t, ob, v)
+		}
+		return ob
+	}
+
+	t.Run("quote pair", func(t *testing.T) {
+		ob := init(t)
+		bid := &Order{ID: 3, Price: decimal.NewFromFloat(9.5), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection}
+		ask := &Order{ID: 4, Price: decimal.NewFromFloat(9.8), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: SellOrderDirection}
+
+		tr, err := ob.SubmitBatch([]*Order{bid, ask})
+		require.NoError(t, err)
+		require.Equal(t, 0, len(tr.Trades()))
+
+		// nothing lands before commit
+		_, _, ok := ob.find(3)
+		require.False(t, ok)
+		best, _ := ob.BestBid()
+		require.True(t, decimal.NewFromFloat(9.0).Equal(best.Price))
+
+		_, err = tr.Commit()
+		require.NoError(t, err)
+		best, _ = ob.BestBid()
+		require.True(t, decimal.NewFromFloat(9.5).Equal(best.Price))
+		best, _ = ob.BestAsk()
+		require.True(t, decimal.NewFromFloat(9.8).Equal(best.Price))
+	})
+
+	t.Run("later orders see earlier ones", func(t *testing.T) {
+		ob := init(t)
+		batch := []*Order{
+			{ID: 3, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+			{ID: 4, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+			// matches the rest of order 4 left by the previous one
+			{ID: 5, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(30.0), Type: LimitOrderType, Dir: SellOrderDirection},
+		}
+
+		maker := ob.sell.index[1].Value.(*Order)
+		tr, err := ob.SubmitBatch(batch)
+		require.NoError(t, err)
+		require.Equal(t, 3, len(tr.Trades()))
+		require.Equal(t, OrderID(4), tr.Trades()[2].Maker)
+		require.True(t, decimal.NewFromFloat(20.0).Equal(tr.Trades()[2].Amount))
+		require.Equal(t, []*Order{batch[0], maker, batch[1]}, tr.Orders())
+
+		trades, err := tr.Commit()
+		require.NoError(t, err)
+		require.Equal(t, []TradeID{1, 2, 3}, []TradeID{trades[0].ID, trades[1].ID, trades[2].ID})
+
+		// the rest of order 5 is resting, order 4 has been filled
+		_, _, ok := ob.find(4)
+		require.False(t, ok)
+		require.True(t, decimal.NewFromFloat(10.0).Equal(ob.sell.Volume()))
+		require.True(t, decimal.NewFromFloat(10.0).Equal(batch[2].Amount))
+		require.True(t, decimal.NewFromFloat(100.0).Equal(ob.buy.Volume()))
+		require.True(t, decimal.NewFromFloat(10.0).Equal(ob.lastPrice))
+	})
+
+	t.Run("all or nothing", func(t *testing.T) {
+		ob := init(t)
+		before := ob.Checksum()
+		_, err := ob.SubmitBatch([]*Order{
+			{ID: 3, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+			{ID: 4, Price: decimal.NewFromFloat(9.0), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: BuyOrderDirection, TimeInForce: FOKTimeInForce},
+		})
+		require.ErrorIs(t, err, ErrOrderKilled)
+		require.Equal(t, before, ob.Checksum())
+
+		_, err = ob.SubmitBatch([]*Order{
+			{ID: 3, Price: decimal.NewFromFloat(9.5), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+			{ID: 3, Price: decimal.NewFromFloat(9.6), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+		})
+		require.ErrorIs(t, err, ErrDuplicateOrder)
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		updates := make([]MarketUpdate, 0)
+		ob := NewOrderBook(WithClock(NewManualClock(0)), WithMarketData(func(u MarketUpdate) {
+			updates = append(updates, u)
+		}))
+		submitOrder(t, ob, Order{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Display: decimal.NewFromFloat(30.0), Type: LimitOrderType, Dir: SellOrderDirection})
+		submitOrder(t, ob, Order{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(50.0), Type: LimitOrderType, Dir: SellOrderDirection, TimeInForce: GTDTimeInForce, ExpireAt: 1_000})
+		submitOrder(t, ob, Order{ID: 3, Price: decimal.NewFromFloat(9.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		// leaves a partially shown iceberg tip
+		submitOrder(t, ob, Order{ID: 4, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+
+		var before bytes.Buffer
+		require.NoError(t, ob.Snapshot(&before))
+		sequence := ob.Sequence()
+
+		batch := []*Order{
+			{ID: 5, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+			{ID: 6, Price: decimal.NewFromFloat(9.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 7, Price: decimal.NewFromFloat(12.0), StopPrice: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(5.0), Type: StopLimitOrderType, Dir: BuyOrderDirection},
+		}
+		tr, err := ob.SubmitBatch(batch)
+		require.NoError(t, err)
+		require.NoError(t, tr.Rollback())
+		_, err = tr.Commit()
+		require.NoError(t, err)
+
+		var after bytes.Buffer
+		require.NoError(t, ob.Snapshot(&after))
+		require.JSONEq(t, before.String(), after.String())
+		require.Equal(t, sequence, ob.Sequence())
+		for _, oc := range []*OrderContainer{ob.buy, ob.sell, ob.buyStops, ob.sellStops} {
+			require.NoError(t, oc.verify())
+		}
+		require.Equal(t, 3, len(ob.sell.index)+len(ob.buy.index))
+		require.Equal(t, 1, len(ob.sell.expiring))
+		require.Equal(t, 0, len(ob.buyStops.index))
+		require.True(t, decimal.NewFromFloat(60.0).Equal(batch[0].Amount))
+		require.Equal(t, MillisecondTimestamp(0), batch[0].Timestamp)
+
+		// the book keeps matching and publishing as if the batch never happened
+		trades := submitTrades(t, ob, Order{ID: 8, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(25.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.Equal(t, []OrderID{1, 2}, []OrderID{trades[0].Maker, trades[1].Maker})
+		require.True(t, decimal.NewFromFloat(20.0).Equal(trades[0].Amount))
+		require.Equal(t, 1, len(updates[len(updates)-1].Deltas))
+	})
+
+	t.Run("stale batch", func(t *testing.T) {
+		ob := init(t)
+		tr, err := ob.SubmitBatch([]*Order{
+			{ID: 3, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+		})
+		require.NoError(t, err)
+		submitOrder(t, ob, Order{ID: 4, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+
+		before := ob.Checksum()
+		_, err = tr.Commit()
+		require.ErrorIs(t, err, ErrStaleTransaction)
+		require.Equal(t, before, ob.Checksum())
+		require.True(t, decimal.NewFromFloat(40.0).Equal(ob.sell.index[1].Value.(*Order).Amount))
+
+		// a copy rolled back later does not touch the book either
+		copied := tr
+		require.NoError(t, tr.Rollback())
+		require.NoError(t, copied.Rollback())
+		require.Equal(t, before, ob.Checksum())
+	})
+
+	t.Run("diverged batch", func(t *testing.T) {
+		ob := init(t)
+		order := &Order{ID: 3, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: BuyOrderDirection}
+		tr, err := ob.SubmitBatch([]*Order{order})
+		require.NoError(t, err)
+
+		before := ob.Checksum()
+		order.Amount = decimal.NewFromFloat(120.0)
+		_, err = tr.Commit()
+		require.ErrorIs(t, err, ErrBatchDiverged)
+		require.Equal(t, before, ob.Checksum())
+		require.True(t, decimal.NewFromFloat(120.0).Equal(order.Amount))
+		_, _, ok := ob.find(3)
+		require.False(t, ok)
+	})
+}
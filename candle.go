@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/shopspring/decimal"
+)
+
+// CandleInterval is a candle length in milliseconds
+type CandleInterval MillisecondTimestamp
+
+const (
+	SecondCandleInterval CandleInterval = 1000
+	MinuteCandleInterval                = 60 * SecondCandleInterval
+	HourCandleInterval                  = 60 * MinuteCandleInterval
+	DayCandleInterval                   = 24 * HourCandleInterval
+)
+
+// Candle is an OHLCV bar of trades in [Start, Start+interval)
+type Candle struct {
+	Start    MillisecondTimestamp `json:"start"`
+	Open     decimal.Decimal      `json:"open"`
+	High     decimal.Decimal      `json:"high"`
+	Low      decimal.Decimal      `json:"low"`
+	Close    decimal.Decimal      `json:"close"`
+	Volume   decimal.Decimal      `json:"volume"`
+	Notional decimal.Decimal      `json:"notional"`
+	VWAP     decimal.Decimal      `json:"vwap"`
+	Trades   int                  `json:"trades"`
+}
+
+// CandleAggregator builds candles of one interval from committed trades.
+// Intervals without trades produce no candles, a candle is closed by the first trade or Tick past its end
+type CandleAggregator struct {
+	interval CandleInterval
+	current  *Candle
+	closed   func(Candle)
+}
+
+func NewCandleAggregator(interval CandleInterval, closed func(Candle)) (*CandleAggregator, error) {
+	if interval <= 0 {
+		return nil, ErrBadInterval
+	}
+	return &CandleAggregator{
+		interval: interval,
+		closed:   closed,
+	}, nil
+}
+
+// Current returns the candle being built, false if there were no trades since the last one closed
+func (ca *CandleAggregator) Current() (Candle, bool) {
+	if ca.current == nil {
+		return Candle{}, false
+	}
+	return *ca.current, true
+}
+
+// Add applies trades in execution order
+func (ca *CandleAggregator) Add(trades ...Trade) error {
+	for _, trade := range trades {
+		if err := ca.add(trade); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ca *CandleAggregator) add(trade Trade) error {
+	if trade.Timestamp <= 0 {
+		return ErrBadTimestamp
+	}
+
+	start := ca.start(trade.Timestamp)
+	if ca.current != nil && start < ca.current.Start {
+		return ErrStaleTrade
+	}
+
+	ca.Tick(trade.Timestamp)
+	if ca.current == nil {
+		ca.current = &Candle{
+			Start:    start,
+			Open:     trade.Price,
+			High:     trade.Price,
+			Low:      trade.Price,
+			Volume:   decimal.Zero,
+			Notional: decimal.Zero,
+		}
+	}
+
+	c := ca.current
+	if trade.Price.GreaterThan(c.High) {
+		c.High = trade.Price
+	}
+	if trade.Price.LessThan(c.Low) {
+		c.Low = trade.Price
+	}
+	c.Close = trade.Price
+	c.Volume = c.Volume.Add(trade.Amount)
+	c.Notional = c.Notional.Add(trade.Amount.Mul(trade.Price))
+	c.VWAP = c.Notional.Div(c.Volume)
+	c.Trades++
+	return nil
+}
+
+// Tick closes the current candle once now is past its end
+func (ca *CandleAggregator) Tick(now MillisecondTimestamp) {
+	if ca.current == nil || ca.start(now) <= ca.current.Start {
+		return
+	}
+
+	c := *ca.current
+	ca.current = nil
+	if ca.closed != nil {
+		ca.closed(c)
+	}
+}
+
+func (ca *CandleAggregator) start(ts MillisecondTimestamp) MillisecondTimestamp {
+	return ts - ts%MillisecondTimestamp(ca.interval)
+}
+
+var (
+	ErrBadInterval  = errors.New("bad candle interval")
+	ErrBadTimestamp = errors.New("bad timestamp value")
+	ErrStaleTrade   = errors.New("trade is older than current candle")
+)
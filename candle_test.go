@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCandles(t *testing.T) {
+	trade := func(ts MillisecondTimestamp, price, amount float64) Trade {
+		return Trade{Timestamp: ts, Price: decimal.NewFromFloat(price), Amount: decimal.NewFromFloat(amount)}
+	}
+
+	t.Run("ohlcv", func(t *testing.T) {
+		closed := make([]Candle, 0)
+		ca, err := NewCandleAggregator(MinuteCandleInterval, func(c Candle) {
+			closed = append(closed, c)
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, ca.Add(
+			trade(60_000, 10, 1),
+			trade(70_000, 12, 2),
+			trade(80_000, 9, 1),
+			trade(119_999, 11, 4),
+		))
+		require.Equal(t, 0, len(closed))
+
+		c, ok := ca.Current()
+		require.True(t, ok)
+		require.Equal(t, MillisecondTimestamp(60_000), c.Start)
+		require.True(t, decimal.NewFromFloat(10).Equal(c.Open))
+		require.True(t, decimal.NewFromFloat(12).Equal(c.High))
+		require.True(t, decimal.NewFromFloat(9).Equal(c.Low))
+		require.True(t, decimal.NewFromFloat(11).Equal(c.Close))
+		require.True(t, decimal.NewFromFloat(8).Equal(c.Volume))
+		// (10 + 24 + 9 + 44) / 8
+		require.True(t, decimal.NewFromFloat(10.875).Equal(c.VWAP))
+		require.Equal(t, 4, c.Trades)
+
+		require.NoError(t, ca.Add(trade(120_000, 13, 1)))
+		require.Equal(t, 1, len(closed))
+		require.Equal(t, c, closed[0])
+	})
+
+	t.Run("gaps", func(t *testing.T) {
+		closed := make([]Candle, 0)
+		ca, err := NewCandleAggregator(SecondCandleInterval, func(c Candle) {
+			closed = append(closed, c)
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, ca.Add(trade(1_500, 10, 1), trade(9_200, 11, 1)))
+		require.Equal(t, 1, len(closed))
+		require.Equal(t, MillisecondTimestamp(1_000), closed[0].Start)
+
+		c, _ := ca.Current()
+		require.Equal(t, MillisecondTimestamp(9_000), c.Start)
+		require.True(t, decimal.NewFromFloat(11).Equal(c.Open))
+
+		ca.Tick(9_999)
+		require.Equal(t, 1, len(closed))
+		ca.Tick(60_000)
+		require.Equal(t, 2, len(closed))
+		_, ok := ca.Current()
+		require.False(t, ok)
+	})
+
+	t.Run("bad trades", func(t *testing.T) {
+		ca, err := NewCandleAggregator(HourCandleInterval, nil)
+		require.NoError(t, err)
+
+		require.ErrorIs(t, ca.Add(trade(0, 10, 1)), ErrBadTimestamp)
+		require.NoError(t, ca.Add(trade(2*MillisecondTimestamp(HourCandleInterval), 10, 1)))
+		require.ErrorIs(t, ca.Add(trade(1, 10, 1)), ErrStaleTrade)
+
+		_, err = NewCandleAggregator(0, nil)
+		require.ErrorIs(t, err, ErrBadInterval)
+	})
+
+	t.Run("book trades", func(t *testing.T) {
+		clock := NewManualClock(MillisecondTimestamp(DayCandleInterval) + 1_000)
+		ob := NewOrderBook(WithClock(clock))
+		ca, err := NewCandleAggregator(DayCandleInterval, nil)
+		require.NoError(t, err)
+
+		submitOrder(t, ob, Order{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection})
+		trades := submitTrades(t, ob, Order{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(30.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.NoError(t, ca.Add(trades...))
+
+		c, ok := ca.Current()
+		require.True(t, ok)
+		require.Equal(t, MillisecondTimestamp(DayCandleInterval), c.Start)
+		require.True(t, decimal.NewFromFloat(30).Equal(c.Volume))
+	})
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"hash/crc32"
+)
+
+const defaultChecksumLevels = 10
+
+// WithChecksumLevels sets how many top levels of each side are covered by OrderBook.Checksum
+func WithChecksumLevels(levels int) OrderBookOption {
+	return func(ob *OrderBook) {
+		ob.checksumLevels = levels
+	}
+}
+
+// Checksum returns CRC32 of the top levels of the book, see Depth.Checksum
+func (ob *OrderBook) Checksum() uint32 {
+	return ob.Depth(ob.checksumLevels).Checksum()
+}
+
+// Checksum returns IEEE CRC32 of "price:volume" pairs joined with "|",
+// asks best to worst followed by bids best to worst, decimals are formatted without trailing zeros
+func (d Depth) Checksum() uint32 {
+	h := crc32.NewIEEE()
+	sep := []byte{}
+	for _, levels := range [][]PriceLevel{d.Asks, d.Bids} {
+		for _, l := range levels {
+			h.Write(sep)
+			h.Write([]byte(l.Price.String()))
+			h.Write([]byte{':'})
+			h.Write([]byte(l.Volume.String()))
+			sep = []byte{'|'}
+		}
+	}
+	return h.Sum32()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"hash/crc32"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksum(t *testing.T) {
+	init := func(t *testing.T, opts ...OrderBookOption) *OrderBook {
+		ob := NewOrderBook(opts...)
+		orders := []Order{
+			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+			{ID: 2, Price: decimal.NewFromFloat(9.5), Amount: decimal.NewFromFloat(20.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+			{ID: 3, Price: decimal.RequireFromString("11.50"), Amount: decimal.NewFromFloat(5.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 4, Price: decimal.NewFromFloat(12.0), Amount: decimal.RequireFromString("7.250"), Type: LimitOrderType, Dir: SellOrderDirection},
+		}
+		for _, v := range orders {
+			submitOrder(t, ob, v)
+		}
+		return ob
+	}
+
+	t.Run("format", func(t *testing.T) {
+		ob := init(t)
+		require.Equal(t, crc32.ChecksumIEEE([]byte("11.5:5|12:7.25|10:100|9.5:20")), ob.Checksum())
+	})
+
+	t.Run("levels", func(t *testing.T) {
+		ob := init(t, WithChecksumLevels(1))
+		require.Equal(t, crc32.ChecksumIEEE([]byte("11.5:5|10:100")), ob.Checksum())
+	})
+
+	t.Run("changes with the book", func(t *testing.T) {
+		ob := init(t)
+		before := ob.Checksum()
+		require.Equal(t, before, init(t).Checksum())
+
+		submitOrder(t, ob, Order{ID: 5, Price: decimal.NewFromFloat(9.0), Amount: decimal.NewFromFloat(1.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.NotEqual(t, before, ob.Checksum())
+	})
+
+	t.Run("empty book", func(t *testing.T) {
+		require.Equal(t, crc32.ChecksumIEEE(nil), NewOrderBook().Checksum())
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides time for order and trade timestamps
+type Clock interface {
+	Now() MillisecondTimestamp
+}
+
+// SystemClock reads the wall clock
+type SystemClock struct{}
+
+func (SystemClock) Now() MillisecondTimestamp {
+	return MillisecondTimestamp(time.Now().UnixMilli())
+}
+
+// ManualClock only moves when told to, it is meant for tests and replays
+type ManualClock struct {
+	mu  sync.Mutex
+	now MillisecondTimestamp
+}
+
+func NewManualClock(now MillisecondTimestamp) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() MillisecondTimestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) Set(now MillisecondTimestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now += MillisecondTimestamp(d.Milliseconds())
+}
+
+func WithClock(clock Clock) OrderBookOption {
+	return func(ob *OrderBook) {
+		ob.clock = clock
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClock(t *testing.T) {
+	t.Run("manual clock", func(t *testing.T) {
+		clock := NewManualClock(1_000)
+		require.Equal(t, MillisecondTimestamp(1_000), clock.Now())
+		clock.Advance(time.Second)
+		require.Equal(t, MillisecondTimestamp(2_000), clock.Now())
+		clock.Set(5)
+		require.Equal(t, MillisecondTimestamp(5), clock.Now())
+	})
+
+	t.Run("system clock", func(t *testing.T) {
+		before := time.Now().UnixMilli()
+		now := SystemClock{}.Now()
+		require.GreaterOrEqual(t, int64(now), before)
+	})
+
+	t.Run("orders and trades are stamped", func(t *testing.T) {
+		clock := NewManualClock(1_000)
+		ob := NewOrderBook(WithClock(clock))
+
+		maker := &Order{ID: 1, Timestamp: 42, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection}
+		tr, err := ob.SubmitOrder(maker)
+		require.NoError(t, err)
+		_, err = tr.Commit()
+		require.NoError(t, err)
+		require.Equal(t, MillisecondTimestamp(1_000), maker.Timestamp)
+
+		clock.Advance(time.Minute)
+		taker := &Order{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection}
+		tr, err = ob.SubmitOrder(taker)
+		require.NoError(t, err)
+		require.Equal(t, MillisecondTimestamp(61_000), taker.Timestamp)
+
+		clock.Advance(time.Second)
+		trades, err := tr.Commit()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(trades))
+		require.Equal(t, MillisecondTimestamp(62_000), trades[0].Timestamp)
+	})
+
+	t.Run("amend with new priority is restamped", func(t *testing.T) {
+		clock := NewManualClock(1_000)
+		ob := NewOrderBook(WithClock(clock))
+		submitOrder(t, ob, Order{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection})
+
+		clock.Set(2_000)
+		tr, err := ob.AmendOrder(1, decimal.NewFromFloat(10.0), decimal.NewFromFloat(50.0))
+		require.NoError(t, err)
+		_, err = tr.Commit()
+		require.NoError(t, err)
+		_, el, _ := ob.find(1)
+		require.Equal(t, MillisecondTimestamp(1_000), el.Value.(*Order).Timestamp)
+
+		tr, err = ob.AmendOrder(1, decimal.NewFromFloat(11.0), decimal.NewFromFloat(50.0))
+		require.NoError(t, err)
+		_, err = tr.Commit()
+		require.NoError(t, err)
+		_, el, _ = ob.find(1)
+		require.Equal(t, MillisecondTimestamp(2_000), el.Value.(*Order).Timestamp)
+	})
+
+	t.Run("deterministic expiry", func(t *testing.T) {
+		clock := NewManualClock(1_000)
+		ob := NewOrderBook(WithClock(clock))
+		submitOrder(t, ob, Order{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection, TimeInForce: GTDTimeInForce, ExpireAt: 5_000})
+
+		clock.Advance(5 * time.Second)
+		tr, err := ob.ExpireOrders(clock.Now())
+		require.NoError(t, err)
+		require.Equal(t, 1, len(tr.Orders()))
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	rbtree "github.com/emirpasic/gods/trees/redblacktree"
+	"github.com/shopspring/decimal"
+)
+
+// PriceLevel is an aggregated price level, Volume is the displayed volume
+type PriceLevel struct {
+	Price  decimal.Decimal `json:"price"`
+	Volume decimal.Decimal `json:"volume"`
+	Orders int             `json:"orders"`
+}
+
+// Depth is a book snapshot as of market update Sequence
+type Depth struct {
+	Sequence uint64       `json:"seq"`
+	Bids     []PriceLevel `json:"bids"`
+	Asks     []PriceLevel `json:"asks"`
+}
+
+// Depth returns up to levels price levels of each side best to worst, all of them if levels <= 0
+func (ob *OrderBook) Depth(levels int) Depth {
+	return Depth{
+		Sequence: ob.sequence,
+		Bids:     ob.buy.levels(ob.buy.priceTree.Right(), nextMaxNode, levels),
+		Asks:     ob.sell.levels(ob.sell.priceTree.Left(), nextMinNode, levels),
+	}
+}
+
+func (oc *OrderContainer) levels(node *rbtree.Node, next func(*rbtree.Node) *rbtree.Node, limit int) []PriceLevel {
+	size := oc.priceTree.Size()
+	if limit > 0 && limit < size {
+		size = limit
+	}
+
+	levels := make([]PriceLevel, 0, size)
+	for ; node != nil && len(levels) < size; node = next(node) {
+		levels = append(levels, node.Value.(*OrderQueue).level())
+	}
+	return levels
+}
+
+// BestBid returns the highest buy level, false if there are no bids
+func (ob *OrderBook) BestBid() (PriceLevel, bool) {
+	return bestLevel(ob.buy.priceTree.Right())
+}
+
+// BestAsk returns the lowest sell level, false if there are no asks
+func (ob *OrderBook) BestAsk() (PriceLevel, bool) {
+	return bestLevel(ob.sell.priceTree.Left())
+}
+
+// Spread returns best ask minus best bid, false unless both sides are present
+func (ob *OrderBook) Spread() (decimal.Decimal, bool) {
+	bid, ask, ok := ob.bbo()
+	if !ok {
+		return decimal.Zero, false
+	}
+	return ask.Sub(bid), true
+}
+
+// MidPrice returns the average of best bid and best ask, false unless both sides are present
+func (ob *OrderBook) MidPrice() (decimal.Decimal, bool) {
+	bid, ask, ok := ob.bbo()
+	if !ok {
+		return decimal.Zero, false
+	}
+	return bid.Add(ask).Div(decimal.NewFromInt(2)), true
+}
+
+func (ob *OrderBook) bbo() (decimal.Decimal, decimal.Decimal, bool) {
+	bid, ok := ob.BestBid()
+	if !ok {
+		return decimal.Zero, decimal.Zero, false
+	}
+	ask, ok := ob.BestAsk()
+	if !ok {
+		return decimal.Zero, decimal.Zero, false
+	}
+	return bid.Price, ask.Price, true
+}
+
+func bestLevel(node *rbtree.Node) (PriceLevel, bool) {
+	if node == nil {
+		return PriceLevel{}, false
+	}
+	return node.Value.(*OrderQueue).level(), true
+}
+
+func (oq *OrderQueue) level() PriceLevel {
+	return PriceLevel{
+		Price:  oq.Price(),
+		Volume: oq.Volume(),
+		Orders: oq.orders.Len(),
+	}
+}
+
+// BookOrder is a resting order in the level-3 view, Position is zero based within the price level
+type BookOrder struct {
+	ID       OrderID         `json:"id"`
+	Price    decimal.Decimal `json:"price"`
+	Amount   decimal.Decimal `json:"amount"`
+	Visible  decimal.Decimal `json:"visible"`
+	Position int             `json:"position"`
+}
+
+// WalkOrders calls fn for every resting order of one side, levels best to worst and orders in
+// queue priority, walking stops when fn returns false
+func (ob *OrderBook) WalkOrders(dir OrderDirection, fn func(BookOrder) bool) {
+	node, next := ob.sell.priceTree.Left(), nextMinNode
+	if dir == BuyOrderDirection {
+		node, next = ob.buy.priceTree.Right(), nextMaxNode
+	}
+
+	for ; node != nil; node = next(node) {
+		queue := node.Value.(*OrderQueue)
+		pos := 0
+		for el := queue.orders.Front(); el != nil; el = el.Next() {
+			order := el.Value.(*Order)
+			if !fn(BookOrder{ID: order.ID, Price: queue.Price(), Amount: order.Amount, Visible: order.visible(), Position: pos}) {
+				return
+			}
+			pos++
+		}
+	}
+}
+
+// LastPrice returns the price of the last trade, false if there were no trades yet
+func (ob *OrderBook) LastPrice() (decimal.Decimal, bool) {
+	return ob.lastPrice, !ob.lastPrice.IsZero()
+}
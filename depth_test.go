@@ -0,0 +1,148 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDepth(t *testing.T) {
+	init := func(t *testing.T) *OrderBook {
+		ob := NewOrderBook()
+		orders := []Order{
+			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+			{ID: 2, Price: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(50.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+			{ID: 3, Price: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(30.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+			{ID: 4, Price: decimal.NewFromFloat(9.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+			{ID: 5, Price: decimal.NewFromFloat(13.0), Amount: decimal.NewFromFloat(40.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 6, Price: decimal.NewFromFloat(12.0), Amount: decimal.NewFromFloat(100.0), Display: decimal.NewFromFloat(20.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 7, Price: decimal.NewFromFloat(12.0), Amount: decimal.NewFromFloat(5.0), Type: LimitOrderType, Dir: SellOrderDirection},
+		}
+		for _, v := range orders {
+			submitOrder(t, ob, v)
+		}
+		return ob
+	}
+
+	requireLevels := func(t *testing.T, expected [][3]float64, levels []PriceLevel) {
+		require.Equal(t, len(expected), len(levels))
+		for i, v := range expected {
+			require.True(t, decimal.NewFromFloat(v[0]).Equal(levels[i].Price))
+			require.True(t, decimal.NewFromFloat(v[1]).Equal(levels[i].Volume))
+			require.Equal(t, int(v[2]), levels[i].Orders)
+		}
+	}
+
+	t.Run("full depth", func(t *testing.T) {
+		ob := init(t)
+		depth := ob.Depth(0)
+		requireLevels(t, [][3]float64{{11, 80, 2}, {10, 100, 1}, {9, 10, 1}}, depth.Bids)
+		// hidden iceberg reserve is not published
+		requireLevels(t, [][3]float64{{12, 25, 2}, {13, 40, 1}}, depth.Asks)
+	})
+
+	t.Run("limited depth", func(t *testing.T) {
+		ob := init(t)
+		depth := ob.Depth(1)
+		requireLevels(t, [][3]float64{{11, 80, 2}}, depth.Bids)
+		requireLevels(t, [][3]float64{{12, 25, 2}}, depth.Asks)
+	})
+
+	t.Run("after trades", func(t *testing.T) {
+		ob := init(t)
+		submitOrder(t, ob, Order{ID: 8, Price: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: SellOrderDirection})
+		depth := ob.Depth(2)
+		requireLevels(t, [][3]float64{{11, 20, 1}, {10, 100, 1}}, depth.Bids)
+	})
+
+	t.Run("empty book", func(t *testing.T) {
+		depth := NewOrderBook().Depth(5)
+		require.Equal(t, 0, len(depth.Bids))
+		require.Equal(t, 0, len(depth.Asks))
+	})
+}
+
+func TestTopOfBook(t *testing.T) {
+	ob := NewOrderBook()
+
+	_, ok := ob.BestBid()
+	require.False(t, ok)
+	_, ok = ob.BestAsk()
+	require.False(t, ok)
+
+	submitOrder(t, ob, Order{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+	submitOrder(t, ob, Order{ID: 2, Price: decimal.NewFromFloat(10.5), Amount: decimal.NewFromFloat(20.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+
+	bid, ok := ob.BestBid()
+	require.True(t, ok)
+	require.True(t, decimal.NewFromFloat(10.5).Equal(bid.Price))
+	require.True(t, decimal.NewFromFloat(20.0).Equal(bid.Volume))
+	_, ok = ob.Spread()
+	require.False(t, ok)
+	_, ok = ob.MidPrice()
+	require.False(t, ok)
+
+	submitOrder(t, ob, Order{ID: 3, Price: decimal.NewFromFloat(12.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: SellOrderDirection})
+	submitOrder(t, ob, Order{ID: 4, Price: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(30.0), Type: LimitOrderType, Dir: SellOrderDirection})
+
+	ask, ok := ob.BestAsk()
+	require.True(t, ok)
+	require.True(t, decimal.NewFromFloat(11.0).Equal(ask.Price))
+	require.Equal(t, 1, ask.Orders)
+
+	spread, ok := ob.Spread()
+	require.True(t, ok)
+	require.True(t, decimal.NewFromFloat(0.5).Equal(spread))
+
+	mid, ok := ob.MidPrice()
+	require.True(t, ok)
+	require.True(t, decimal.NewFromFloat(10.75).Equal(mid))
+}
+
+func TestWalkOrders(t *testing.T) {
+	ob := NewOrderBook()
+	orders := []Order{
+		{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+		{ID: 2, Price: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(50.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+		{ID: 3, Price: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(30.0), Display: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+		{ID: 4, Price: decimal.NewFromFloat(13.0), Amount: decimal.NewFromFloat(40.0), Type: LimitOrderType, Dir: SellOrderDirection},
+		{ID: 5, Price: decimal.NewFromFloat(12.0), Amount: decimal.NewFromFloat(5.0), Type: LimitOrderType, Dir: SellOrderDirection},
+	}
+	for _, v := range orders {
+		submitOrder(t, ob, v)
+	}
+
+	t.Run("bids", func(t *testing.T) {
+		walked := make([]BookOrder, 0)
+		ob.WalkOrders(BuyOrderDirection, func(o BookOrder) bool {
+			walked = append(walked, o)
+			return true
+		})
+
+		require.Equal(t, 3, len(walked))
+		require.Equal(t, []OrderID{2, 3, 1}, []OrderID{walked[0].ID, walked[1].ID, walked[2].ID})
+		require.Equal(t, []int{0, 1, 0}, []int{walked[0].Position, walked[1].Position, walked[2].Position})
+		require.True(t, decimal.NewFromFloat(30.0).Equal(walked[1].Amount))
+		require.True(t, decimal.NewFromFloat(10.0).Equal(walked[1].Visible))
+		require.True(t, decimal.NewFromFloat(11.0).Equal(walked[1].Price))
+	})
+
+	t.Run("asks", func(t *testing.T) {
+		ids := make([]OrderID, 0)
+		ob.WalkOrders(SellOrderDirection, func(o BookOrder) bool {
+			ids = append(ids, o.ID)
+			return true
+		})
+		require.Equal(t, []OrderID{5, 4}, ids)
+	})
+
+	t.Run("stop early", func(t *testing.T) {
+		calls := 0
+		ob.WalkOrders(BuyOrderDirection, func(o BookOrder) bool {
+			calls++
+			return calls < 2
+		})
+		require.Equal(t, 2, calls)
+	})
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+type Symbol string
+
+type InstrumentStatus uint8
+
+const (
+	ActiveInstrumentStatus    InstrumentStatus = iota
+	SuspendedInstrumentStatus                  // cancels only, no new orders or amends
+)
+
+// Listing is an instrument listed on the exchange
+type Listing struct {
+	Symbol Symbol           `json:"symbol"`
+	Status InstrumentStatus `json:"status"`
+}
+
+// BBO is the top of one book, Bid and Ask are nil for an empty side
+type BBO struct {
+	Bid *PriceLevel `json:"bid"`
+	Ask *PriceLevel `json:"ask"`
+}
+
+type market struct {
+	book   *OrderBook
+	status InstrumentStatus
+}
+
+// Exchange owns an OrderBook per instrument and routes commands by symbol
+type Exchange struct {
+	markets map[Symbol]*market
+}
+
+func NewExchange() *Exchange {
+	return &Exchange{
+		markets: make(map[Symbol]*market),
+	}
+}
+
+// AddInstrument lists a new active instrument with its own book
+func (e *Exchange) AddInstrument(symbol Symbol, opts ...OrderBookOption) (*OrderBook, error) {
+	if symbol == "" {
+		return nil, ErrBadSymbol
+	}
+	if _, ok := e.markets[symbol]; ok {
+		return nil, ErrDuplicateInstrument
+	}
+
+	book := NewOrderBook(opts...)
+	e.markets[symbol] = &market{book: book}
+	return book, nil
+}
+
+// Book returns the instrument book for reads, commands sent to it directly bypass suspension
+func (e *Exchange) Book(symbol Symbol) (*OrderBook, error) {
+	m, err := e.market(symbol)
+	if err != nil {
+		return nil, err
+	}
+	return m.book, nil
+}
+
+// Instruments returns listed instruments ordered by symbol
+func (e *Exchange) Instruments() []Listing {
+	instruments := make([]Listing, 0, len(e.markets))
+	for symbol, m := range e.markets {
+		instruments = append(instruments, Listing{Symbol: symbol, Status: m.status})
+	}
+	sort.Slice(instruments, func(i, j int) bool {
+		return instruments[i].Symbol < instruments[j].Symbol
+	})
+	return instruments
+}
+
+// Suspend halts trading, transactions evaluated before the halt can not be committed anymore
+func (e *Exchange) Suspend(symbol Symbol) error {
+	m, err := e.market(symbol)
+	if err != nil {
+		return err
+	}
+	m.status = SuspendedInstrumentStatus
+	m.book.invalidate()
+	return nil
+}
+
+func (e *Exchange) Resume(symbol Symbol) error {
+	m, err := e.market(symbol)
+	if err != nil {
+		return err
+	}
+	m.status = ActiveInstrumentStatus
+	return nil
+}
+
+// Delist removes the instrument together with its book and returns resting orders and stops it cancels, ordered by ID
+func (e *Exchange) Delist(symbol Symbol) ([]Cancellation, error) {
+	m, err := e.market(symbol)
+	if err != nil {
+		return nil, err
+	}
+	m.book.invalidate()
+	delete(e.markets, symbol)
+
+	cancelled := make([]Cancellation, 0)
+	for _, oc := range []*OrderContainer{m.book.buy, m.book.sell, m.book.buyStops, m.book.sellStops} {
+		for _, el := range oc.index {
+			cancelled = append(cancelled, cancellation(el.Value.(*Order)))
+		}
+	}
+	sort.Slice(cancelled, func(i, j int) bool {
+		return cancelled[i].ID < cancelled[j].ID
+	})
+	return cancelled, nil
+}
+
+func (e *Exchange) SubmitOrder(symbol Symbol, order *Order) (Transaction, error) {
+	m, err := e.active(symbol)
+	if err != nil {
+		return Transaction{}, err
+	}
+	return m.book.SubmitOrder(order)
+}
+
+// CancelOrder is allowed for suspended instruments as well
+func (e *Exchange) CancelOrder(symbol Symbol, id OrderID) (Transaction, error) {
+	m, err := e.market(symbol)
+	if err != nil {
+		return Transaction{}, err
+	}
+	return m.book.CancelOrder(id)
+}
+
+func (e *Exchange) AmendOrder(symbol Symbol, id OrderID, price, amount decimal.Decimal) (Transaction, error) {
+	m, err := e.active(symbol)
+	if err != nil {
+		return Transaction{}, err
+	}
+	return m.book.AmendOrder(id, price, amount)
+}
+
+// BBOs returns top of book of every instrument
+func (e *Exchange) BBOs() map[Symbol]BBO {
+	bbos := make(map[Symbol]BBO, len(e.markets))
+	for symbol, m := range e.markets {
+		var bbo BBO
+		if bid, ok := m.book.BestBid(); ok {
+			bbo.Bid = &bid
+		}
+		if ask, ok := m.book.BestAsk(); ok {
+			bbo.Ask = &ask
+		}
+		bbos[symbol] = bbo
+	}
+	return bbos
+}
+
+// LastPrices returns last trade prices, instruments without trades are left out
+func (e *Exchange) LastPrices() map[Symbol]decimal.Decimal {
+	prices := make(map[Symbol]decimal.Decimal, len(e.markets))
+	for symbol, m := range e.markets {
+		if price, ok := m.book.LastPrice(); ok {
+			prices[symbol] = price
+		}
+	}
+	return prices
+}
+
+func (e *Exchange) market(symbol Symbol) (*market, error) {
+	m, ok := e.markets[symbol]
+	if !ok {
+		return nil, ErrInstrumentNotFound
+	}
+	return m, nil
+}
+
+func (e *Exchange) active(symbol Symbol) (*market, error) {
+	m, err := e.market(symbol)
+	if err != nil {
+		return nil, err
+	}
+	if m.status != ActiveInstrumentStatus {
+		return nil, ErrInstrumentSuspended
+	}
+	return m, nil
+}
+
+var (
+	ErrBadSymbol           = errors.New("bad instrument symbol")
+	ErrInstrumentNotFound  = errors.New("instrument not found")
+	ErrDuplicateInstrument = errors.New("duplicate instrument symbol")
+	ErrInstrumentSuspended = errors.New("instrument is suspended")
+)
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExchange(t *testing.T) {
+	submit := func(t *testing.T, e *Exchange, symbol Symbol, o Order) []Trade {
+		tr, err := e.SubmitOrder(symbol, &o)
+		require.NoError(t, err)
+		trades, err := tr.Commit()
+		require.NoError(t, err)
+		return trades
+	}
+
+	init := func(t *testing.T) *Exchange {
+		e := NewExchange()
+		_, err := e.AddInstrument("ETH-USD")
+		require.NoError(t, err)
+		_, err = e.AddInstrument("BTC-USD", WithTickSize(decimal.NewFromFloat(0.5)))
+		require.NoError(t, err)
+
+		submit(t, e, "BTC-USD", Order{ID: 1, Price: decimal.NewFromFloat(100.0), Amount: decimal.NewFromFloat(1.0), Type: LimitOrderType, Dir: SellOrderDirection})
+		submit(t, e, "BTC-USD", Order{ID: 2, Price: decimal.NewFromFloat(99.0), Amount: decimal.NewFromFloat(2.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		// same order ID lives in a different book
+		submit(t, e, "ETH-USD", Order{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(5.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		return e
+	}
+
+	t.Run("instruments", func(t *testing.T) {
+		e := init(t)
+		require.Equal(t, []Listing{{Symbol: "BTC-USD"}, {Symbol: "ETH-USD"}}, e.Instruments())
+
+		_, err := e.AddInstrument("BTC-USD")
+		require.ErrorIs(t, err, ErrDuplicateInstrument)
+		_, err = e.AddInstrument("")
+		require.ErrorIs(t, err, ErrBadSymbol)
+
+		cancelled, err := e.Delist("ETH-USD")
+		require.NoError(t, err)
+		require.Equal(t, 1, len(cancelled))
+		require.Equal(t, OrderID(1), cancelled[0].ID)
+		require.True(t, decimal.NewFromFloat(5.0).Equal(cancelled[0].Amount))
+		require.Equal(t, []Listing{{Symbol: "BTC-USD"}}, e.Instruments())
+		_, err = e.Book("ETH-USD")
+		require.ErrorIs(t, err, ErrInstrumentNotFound)
+		_, err = e.SubmitOrder("ETH-USD", &Order{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(5.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.ErrorIs(t, err, ErrInstrumentNotFound)
+	})
+
+	t.Run("routing", func(t *testing.T) {
+		e := init(t)
+		trades := submit(t, e, "BTC-USD", Order{ID: 3, Price: decimal.NewFromFloat(100.0), Amount: decimal.NewFromFloat(0.5), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.Equal(t, 1, len(trades))
+		require.Equal(t, OrderID(1), trades[0].Maker)
+
+		tr, err := e.CancelOrder("ETH-USD", 1)
+		require.NoError(t, err)
+		_, err = tr.Commit()
+		require.NoError(t, err)
+
+		book, err := e.Book("BTC-USD")
+		require.NoError(t, err)
+		_, _, ok := book.find(1)
+		require.True(t, ok)
+	})
+
+	t.Run("suspend", func(t *testing.T) {
+		e := init(t)
+		pending, err := e.SubmitOrder("BTC-USD", &Order{ID: 3, Price: decimal.NewFromFloat(100.0), Amount: decimal.NewFromFloat(0.5), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.NoError(t, err)
+		book, err := e.Book("BTC-USD")
+		require.NoError(t, err)
+		batch, err := book.SubmitBatch([]*Order{{ID: 5, Price: decimal.NewFromFloat(100.0), Amount: decimal.NewFromFloat(0.5), Type: LimitOrderType, Dir: BuyOrderDirection}})
+		require.NoError(t, err)
+
+		require.NoError(t, e.Suspend("BTC-USD"))
+		require.Equal(t, SuspendedInstrumentStatus, e.Instruments()[0].Status)
+
+		_, err = pending.Commit()
+		require.ErrorIs(t, err, ErrStaleTransaction)
+		_, err = batch.Commit()
+		require.ErrorIs(t, err, ErrStaleTransaction)
+		require.True(t, decimal.NewFromFloat(1.0).Equal(book.sell.index[1].Value.(*Order).Amount))
+		_, err = e.SubmitOrder("BTC-USD", &Order{ID: 4, Price: decimal.NewFromFloat(100.0), Amount: decimal.NewFromFloat(0.5), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.ErrorIs(t, err, ErrInstrumentSuspended)
+		_, err = e.AmendOrder("BTC-USD", 2, decimal.NewFromFloat(98.0), decimal.NewFromFloat(2.0))
+		require.ErrorIs(t, err, ErrInstrumentSuspended)
+
+		// cancels still go through
+		tr, err := e.CancelOrder("BTC-USD", 2)
+		require.NoError(t, err)
+		_, err = tr.Commit()
+		require.NoError(t, err)
+
+		require.NoError(t, e.Resume("BTC-USD"))
+		submit(t, e, "BTC-USD", Order{ID: 4, Price: decimal.NewFromFloat(99.0), Amount: decimal.NewFromFloat(0.5), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.ErrorIs(t, e.Suspend("XRP-USD"), ErrInstrumentNotFound)
+	})
+
+	t.Run("market data", func(t *testing.T) {
+		e := init(t)
+		_, err := e.AddInstrument("XRP-USD")
+		require.NoError(t, err)
+		submit(t, e, "BTC-USD", Order{ID: 3, Price: decimal.NewFromFloat(99.0), Amount: decimal.NewFromFloat(0.5), Type: MarketOrderType, Dir: SellOrderDirection})
+
+		bbos := e.BBOs()
+		require.Equal(t, 3, len(bbos))
+		require.True(t, decimal.NewFromFloat(99.0).Equal(bbos["BTC-USD"].Bid.Price))
+		require.True(t, decimal.NewFromFloat(1.5).Equal(bbos["BTC-USD"].Bid.Volume))
+		require.True(t, decimal.NewFromFloat(100.0).Equal(bbos["BTC-USD"].Ask.Price))
+		require.True(t, decimal.NewFromFloat(10.0).Equal(bbos["ETH-USD"].Bid.Price))
+		require.Nil(t, bbos["ETH-USD"].Ask)
+		require.Equal(t, BBO{}, bbos["XRP-USD"])
+
+		prices := e.LastPrices()
+		require.Equal(t, 1, len(prices))
+		require.True(t, decimal.NewFromFloat(99.0).Equal(prices["BTC-USD"]))
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+type LevelAction uint8
+
+const (
+	AddLevelAction LevelAction = iota
+	ChangeLevelAction
+	RemoveLevelAction
+)
+
+// LevelDelta is the new state of a price level, Volume and Orders are zero for removed levels
+type LevelDelta struct {
+	Side   OrderDirection  `json:"side"`
+	Action LevelAction     `json:"action"`
+	Price  decimal.Decimal `json:"price"`
+	Volume decimal.Decimal `json:"volume"`
+	Orders int             `json:"orders"`
+}
+
+// MarketUpdate holds book changes and trades of a committed transaction,
+// Checksum is OrderBook.Checksum after the changes have been applied
+type MarketUpdate struct {
+	Sequence uint64       `json:"seq"`
+	Deltas   []LevelDelta `json:"deltas"`
+	Trades   []Trade      `json:"trades"`
+	Checksum uint32       `json:"checksum"`
+}
+
+// WithMarketData registers fn to receive an update for each committed transaction that changed the book,
+// updates are sequenced, a replica applies them on top of a Depth snapshot with a lower sequence
+func WithMarketData(fn func(MarketUpdate)) OrderBookOption {
+	return func(ob *OrderBook) {
+		ob.feed = fn
+		ob.buy.changes = make(map[priceKey]levelChange)
+		ob.sell.changes = make(map[priceKey]levelChange)
+	}
+}
+
+// Sequence returns the sequence of the last published market update
+func (ob *OrderBook) Sequence() uint64 {
+	return ob.sequence
+}
+
+func (ob *OrderBook) publish(trades []Trade) {
+	if ob.feed == nil {
+		return
+	}
+
+	deltas := append(ob.buy.deltas(BuyOrderDirection), ob.sell.deltas(SellOrderDirection)...)
+	if len(deltas) == 0 && len(trades) == 0 {
+		return
+	}
+
+	ob.sequence++
+	ob.feed(MarketUpdate{
+		Sequence: ob.sequence,
+		Deltas:   deltas,
+		Trades:   trades,
+		Checksum: ob.Checksum(),
+	})
+}
+
+type levelChange struct {
+	price   decimal.Decimal
+	before  PriceLevel
+	existed bool
+}
+
+// touch remembers the price level state before its first change since the last publish,
+// a batch being applied records it for its revert as well
+func (oc *OrderContainer) touch(price decimal.Decimal) {
+	oc.record(price)
+	if oc.changes == nil {
+		return
+	}
+
+	key := price.String()
+	if _, ok := oc.changes[key]; ok {
+		return
+	}
+
+	c := levelChange{price: price}
+	if queue, ok := oc.priceHash[key]; ok {
+		c.before, c.existed = queue.level(), true
+	}
+	oc.changes[key] = c
+}
+
+// deltas returns changed levels best to worst and resets tracking
+func (oc *OrderContainer) deltas(side OrderDirection) []LevelDelta {
+	deltas := make([]LevelDelta, 0, len(oc.changes))
+	for key, c := range oc.changes {
+		queue, ok := oc.priceHash[key]
+		switch {
+		case ok && !c.existed:
+			deltas = append(deltas, levelDelta(side, AddLevelAction, queue.level()))
+		case ok && (!queue.Volume().Equal(c.before.Volume) || queue.orders.Len() != c.before.Orders):
+			deltas = append(deltas, levelDelta(side, ChangeLevelAction, queue.level()))
+		case !ok && c.existed:
+			deltas = append(deltas, levelDelta(side, RemoveLevelAction, PriceLevel{Price: c.price}))
+		}
+		delete(oc.changes, key)
+	}
+
+	sort.Slice(deltas, func(i, j int) bool {
+		if side == BuyOrderDirection {
+			return deltas[i].Price.GreaterThan(deltas[j].Price)
+		}
+		return deltas[i].Price.LessThan(deltas[j].Price)
+	})
+	return deltas
+}
+
+func levelDelta(side OrderDirection, action LevelAction, level PriceLevel) LevelDelta {
+	return LevelDelta{
+		Side:   side,
+		Action: action,
+		Price:  level.Price,
+		Volume: level.Volume,
+		Orders: level.Orders,
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+// replica keeps a local copy of the book from a depth snapshot and market updates
+type replica struct {
+	seq    uint64
+	levels map[OrderDirection]map[string]PriceLevel
+}
+
+func newReplica(depth Depth) *replica {
+	r := &replica{
+		seq: depth.Sequence,
+		levels: map[OrderDirection]map[string]PriceLevel{
+			BuyOrderDirection:  {},
+			SellOrderDirection: {},
+		},
+	}
+	for _, l := range depth.Bids {
+		r.levels[BuyOrderDirection][l.Price.String()] = l
+	}
+	for _, l := range depth.Asks {
+		r.levels[SellOrderDirection][l.Price.String()] = l
+	}
+	return r
+}
+
+func (r *replica) apply(t *testing.T, u MarketUpdate) {
+	require.Equal(t, r.seq+1, u.Sequence)
+	r.seq = u.Sequence
+
+	for _, d := range u.Deltas {
+		levels := r.levels[d.Side]
+		_, ok := levels[d.Price.String()]
+		switch d.Action {
+		case AddLevelAction:
+			require.False(t, ok)
+			levels[d.Price.String()] = PriceLevel{Price: d.Price, Volume: d.Volume, Orders: d.Orders}
+		case ChangeLevelAction:
+			require.True(t, ok)
+			levels[d.Price.String()] = PriceLevel{Price: d.Price, Volume: d.Volume, Orders: d.Orders}
+		case RemoveLevelAction:
+			require.True(t, ok)
+			delete(levels, d.Price.String())
+		}
+	}
+	require.Equal(t, u.Checksum, r.depth(defaultChecksumLevels).Checksum())
+}
+
+func (r *replica) depth(n int) Depth {
+	side := func(dir OrderDirection) []PriceLevel {
+		levels := make([]PriceLevel, 0)
+		for _, l := range r.levels[dir] {
+			levels = append(levels, l)
+		}
+		sort.Slice(levels, func(i, j int) bool {
+			if dir == BuyOrderDirection {
+				return levels[i].Price.GreaterThan(levels[j].Price)
+			}
+			return levels[i].Price.LessThan(levels[j].Price)
+		})
+		if len(levels) > n {
+			levels = levels[:n]
+		}
+		return levels
+	}
+	return Depth{Sequence: r.seq, Bids: side(BuyOrderDirection), Asks: side(SellOrderDirection)}
+}
+
+func (r *replica) requireEqual(t *testing.T, depth Depth) {
+	require.Equal(t, depth.Sequence, r.seq)
+	require.Equal(t, len(depth.Bids), len(r.levels[BuyOrderDirection]))
+	require.Equal(t, len(depth.Asks), len(r.levels[SellOrderDirection]))
+	for _, l := range depth.Bids {
+		require.True(t, l.Volume.Equal(r.levels[BuyOrderDirection][l.Price.String()].Volume))
+		require.Equal(t, l.Orders, r.levels[BuyOrderDirection][l.Price.String()].Orders)
+	}
+	for _, l := range depth.Asks {
+		require.True(t, l.Volume.Equal(r.levels[SellOrderDirection][l.Price.String()].Volume))
+		require.Equal(t, l.Orders, r.levels[SellOrderDirection][l.Price.String()].Orders)
+	}
+}
+
+func TestMarketData(t *testing.T) {
+	updates := make([]MarketUpdate, 0)
+	ob := NewOrderBook(WithMarketData(func(u MarketUpdate) {
+		updates = append(updates, u)
+	}))
+
+	t.Run("levels added", func(t *testing.T) {
+		submitOrder(t, ob, Order{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		submitOrder(t, ob, Order{ID: 2, Price: decimal.NewFromFloat(12.0), Amount: decimal.NewFromFloat(50.0), Type: LimitOrderType, Dir: SellOrderDirection})
+
+		require.Equal(t, 2, len(updates))
+		require.Equal(t, uint64(1), updates[0].Sequence)
+		require.Equal(t, uint64(2), updates[1].Sequence)
+		require.Equal(t, 1, len(updates[0].Deltas))
+		require.Equal(t, BuyOrderDirection, updates[0].Deltas[0].Side)
+		require.Equal(t, AddLevelAction, updates[0].Deltas[0].Action)
+		require.True(t, decimal.NewFromFloat(100.0).Equal(updates[0].Deltas[0].Volume))
+		require.Equal(t, 1, updates[0].Deltas[0].Orders)
+	})
+
+	t.Run("rollback is not published", func(t *testing.T) {
+		tr, err := ob.SubmitOrder(&Order{ID: 3, Price: decimal.NewFromFloat(12.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.NoError(t, err)
+		require.NoError(t, tr.Rollback())
+		require.Equal(t, 2, len(updates))
+	})
+
+	t.Run("trade changes and removes levels", func(t *testing.T) {
+		replica := newReplica(ob.Depth(0))
+
+		submitOrder(t, ob, Order{ID: 4, Price: decimal.NewFromFloat(12.0), Amount: decimal.NewFromFloat(20.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		u := updates[len(updates)-1]
+		require.Equal(t, 1, len(u.Trades))
+		require.Equal(t, []LevelDelta{{Side: SellOrderDirection, Action: ChangeLevelAction, Price: u.Deltas[0].Price, Volume: u.Deltas[0].Volume, Orders: 1}}, u.Deltas)
+		require.True(t, decimal.NewFromFloat(30.0).Equal(u.Deltas[0].Volume))
+		replica.apply(t, u)
+
+		submitOrder(t, ob, Order{ID: 5, Price: decimal.NewFromFloat(13.0), Amount: decimal.NewFromFloat(40.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		u = updates[len(updates)-1]
+		require.Equal(t, 1, len(u.Trades))
+		require.Equal(t, 2, len(u.Deltas))
+		require.Equal(t, AddLevelAction, u.Deltas[0].Action)
+		require.True(t, decimal.NewFromFloat(13.0).Equal(u.Deltas[0].Price))
+		require.True(t, decimal.NewFromFloat(10.0).Equal(u.Deltas[0].Volume))
+		require.Equal(t, RemoveLevelAction, u.Deltas[1].Action)
+		require.True(t, decimal.NewFromFloat(12.0).Equal(u.Deltas[1].Price))
+		replica.apply(t, u)
+
+		replica.requireEqual(t, ob.Depth(0))
+	})
+
+	t.Run("replica follows the book", func(t *testing.T) {
+		replica := newReplica(ob.Depth(0))
+		from := len(updates)
+
+		orders := []Order{
+			{ID: 6, Price: decimal.NewFromFloat(14.0), Amount: decimal.NewFromFloat(100.0), Display: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 7, Price: decimal.NewFromFloat(15.0), Amount: decimal.NewFromFloat(20.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 8, Price: decimal.NewFromFloat(9.0), Amount: decimal.NewFromFloat(20.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+			{ID: 9, Price: decimal.NewFromFloat(1.0), Amount: decimal.NewFromFloat(35.0), Type: MarketOrderType, Dir: BuyOrderDirection},
+			{ID: 10, Price: decimal.NewFromFloat(9.5), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: SellOrderDirection},
+		}
+		for _, v := range orders {
+			submitOrder(t, ob, v)
+		}
+
+		tr, err := ob.AmendOrder(7, decimal.NewFromFloat(15.0), decimal.NewFromFloat(5.0))
+		require.NoError(t, err)
+		_, err = tr.Commit()
+		require.NoError(t, err)
+
+		tr, err = ob.CancelOrder(10)
+		require.NoError(t, err)
+		_, err = tr.Commit()
+		require.NoError(t, err)
+
+		for _, u := range updates[from:] {
+			replica.apply(t, u)
+		}
+		replica.requireEqual(t, ob.Depth(0))
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// Instrument is the trading spec of a book, zero values are not restricted.
+// Precisions are decimal places, integer prices or amounts are enforced with a tick or lot size of 1
+type Instrument struct {
+	TickSize        decimal.Decimal `json:"tick_size"`
+	LotSize         decimal.Decimal `json:"lot_size"`
+	PricePrecision  int32           `json:"price_precision"`
+	AmountPrecision int32           `json:"amount_precision"`
+	MinAmount       decimal.Decimal `json:"min_amount"`
+	MaxAmount       decimal.Decimal `json:"max_amount"`
+	MinNotional     decimal.Decimal `json:"min_notional"`
+}
+
+// WithInstrument attaches the spec to the book, its tick size is used for tick slippage and post-only repricing as well
+func WithInstrument(spec Instrument) OrderBookOption {
+	return func(ob *OrderBook) {
+		ob.instrument = spec
+		ob.buy.spec = spec
+		ob.sell.spec = spec
+		if spec.TickSize.Sign() > 0 {
+			ob.tickSize = spec.TickSize
+		}
+	}
+}
+
+func (ob *OrderBook) Instrument() Instrument {
+	return ob.instrument
+}
+
+// check validates order against the spec, the price is checked only for orders resting at it
+func (in Instrument) check(order *Order) error {
+	if order.Type == LimitOrderType || order.Type == StopLimitOrderType {
+		if err := in.checkPrice(order.Price); err != nil {
+			return err
+		}
+	}
+	if order.Type == StopOrderType || order.Type == StopLimitOrderType {
+		if err := in.checkPrice(order.StopPrice); err != nil {
+			return err
+		}
+	}
+
+	if order.quote() {
+		if in.MinNotional.Sign() > 0 && order.Notional.LessThan(in.MinNotional) {
+			return ErrMinNotional
+		}
+		return nil
+	}
+
+	if err := in.checkAmount(order.Amount); err != nil {
+		return err
+	}
+	if order.iceberg() && !in.multiple(order.Display, in.LotSize) {
+		return ErrOffLotAmount
+	}
+	if in.MinAmount.Sign() > 0 && order.Amount.LessThan(in.MinAmount) {
+		return ErrMinAmount
+	}
+	if in.MaxAmount.Sign() > 0 && order.Amount.GreaterThan(in.MaxAmount) {
+		return ErrMaxAmount
+	}
+
+	// market order notional is known only after matching
+	if order.Type != MarketOrderType && order.Type != StopOrderType &&
+		in.MinNotional.Sign() > 0 && order.Price.Mul(order.Amount).LessThan(in.MinNotional) {
+		return ErrMinNotional
+	}
+	return nil
+}
+
+func (in Instrument) checkPrice(price decimal.Decimal) error {
+	if !in.multiple(price, in.TickSize) {
+		return ErrOffTickPrice
+	}
+	if in.PricePrecision > 0 && !price.Equal(price.Truncate(in.PricePrecision)) {
+		return ErrPricePrecision
+	}
+	return nil
+}
+
+func (in Instrument) checkAmount(amount decimal.Decimal) error {
+	if !in.multiple(amount, in.LotSize) {
+		return ErrOffLotAmount
+	}
+	if in.AmountPrecision > 0 && !amount.Equal(amount.Truncate(in.AmountPrecision)) {
+		return ErrAmountPrecision
+	}
+	return nil
+}
+
+// quoteAmount fits a quote order fill at one level, amount is rounded down to the precision and lot size
+// and capped so that the order does not trade more than the maximum amount in total
+func (in Instrument) quoteAmount(amount, filled decimal.Decimal) decimal.Decimal {
+	if in.MaxAmount.Sign() > 0 {
+		amount = decimal.Min(amount, in.MaxAmount.Sub(filled))
+	}
+	if in.AmountPrecision > 0 {
+		amount = amount.RoundDown(in.AmountPrecision)
+	}
+	if in.LotSize.Sign() > 0 {
+		amount = amount.Sub(amount.Mod(in.LotSize))
+	}
+	return amount
+}
+
+func (in Instrument) multiple(d, step decimal.Decimal) bool {
+	return step.Sign() <= 0 || d.Mod(step).IsZero()
+}
+
+// instrument errors wrap ErrBadPrice and ErrBadAmount, so callers matching those keep working
+var (
+	ErrOffTickPrice   = fmt.Errorf("%w: price is not a multiple of tick size", ErrBadPrice)
+	ErrPricePrecision = fmt.Errorf("%w: too many price decimal places", ErrBadPrice)
+
+	ErrOffLotAmount    = fmt.Errorf("%w: amount is not a multiple of lot size", ErrBadAmount)
+	ErrAmountPrecision = fmt.Errorf("%w: too many amount decimal places", ErrBadAmount)
+	ErrMinAmount       = fmt.Errorf("%w: amount is below minimum", ErrBadAmount)
+	ErrMaxAmount       = fmt.Errorf("%w: amount is above maximum", ErrBadAmount)
+	ErrMinNotional     = fmt.Errorf("%w: notional is below minimum", ErrBadAmount)
+)
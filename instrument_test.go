@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrument(t *testing.T) {
+	spec := Instrument{
+		TickSize:        decimal.RequireFromString("0.05"),
+		LotSize:         decimal.RequireFromString("0.1"),
+		PricePrecision:  2,
+		AmountPrecision: 1,
+		MinAmount:       decimal.RequireFromString("0.5"),
+		MaxAmount:       decimal.NewFromFloat(1000.0),
+		MinNotional:     decimal.NewFromFloat(10.0),
+	}
+
+	limit := func(price, amount string) *Order {
+		return &Order{ID: 1, Price: decimal.RequireFromString(price), Amount: decimal.RequireFromString(amount), Type: LimitOrderType, Dir: BuyOrderDirection}
+	}
+
+	t.Run("accepted", func(t *testing.T) {
+		ob := NewOrderBook(WithInstrument(spec))
+		submitOrder(t, ob, *limit("10.05", "1.5"))
+		require.True(t, spec.TickSize.Equal(ob.tickSize))
+		require.Equal(t, spec, ob.Instrument())
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		ob := NewOrderBook(WithInstrument(spec))
+		cases := []struct {
+			order *Order
+			err   error
+		}{
+			{limit("10.03", "1.5"), ErrOffTickPrice},
+			{limit("10", "1.55"), ErrOffLotAmount},
+			{limit("10", "0.3"), ErrMinAmount},
+			{limit("10", "1000.1"), ErrMaxAmount},
+			{limit("5", "1.5"), ErrMinNotional},
+			{&Order{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(10.0), Display: decimal.RequireFromString("0.25"), Type: LimitOrderType, Dir: BuyOrderDirection}, ErrOffLotAmount},
+			{&Order{ID: 1, Price: decimal.NewFromFloat(1.0), StopPrice: decimal.RequireFromString("10.01"), Amount: decimal.NewFromFloat(1.0), Type: StopOrderType, Dir: BuyOrderDirection}, ErrOffTickPrice},
+			{&Order{ID: 1, Price: decimal.NewFromFloat(1.0), Notional: decimal.NewFromFloat(5.0), Type: MarketOrderType, Dir: BuyOrderDirection}, ErrMinNotional},
+		}
+		for _, c := range cases {
+			_, err := ob.SubmitOrder(c.order)
+			require.ErrorIs(t, err, c.err)
+		}
+
+		// instrument errors are bad prices and amounts as well
+		_, err := ob.SubmitOrder(limit("10.03", "1.5"))
+		require.ErrorIs(t, err, ErrBadPrice)
+		_, err = ob.SubmitOrder(limit("10", "0.3"))
+		require.ErrorIs(t, err, ErrBadAmount)
+	})
+
+	t.Run("precision", func(t *testing.T) {
+		ob := NewOrderBook(WithInstrument(Instrument{PricePrecision: 1, AmountPrecision: 2}))
+		_, err := ob.SubmitOrder(limit("10.25", "1"))
+		require.ErrorIs(t, err, ErrPricePrecision)
+		_, err = ob.SubmitOrder(limit("10.2", "1.001"))
+		require.ErrorIs(t, err, ErrAmountPrecision)
+		// trailing zeros are fine
+		_, err = ob.SubmitOrder(limit("10.20", "1.000"))
+		require.NoError(t, err)
+	})
+
+	t.Run("market orders are not priced", func(t *testing.T) {
+		ob := NewOrderBook(WithInstrument(spec))
+		_, err := ob.SubmitOrder(&Order{ID: 1, Price: decimal.RequireFromString("0.01"), Amount: decimal.NewFromFloat(1.0), Type: MarketOrderType, Dir: BuyOrderDirection})
+		require.NoError(t, err)
+	})
+
+	t.Run("quote orders trade whole lots", func(t *testing.T) {
+		ob := NewOrderBook(WithInstrument(Instrument{LotSize: decimal.NewFromFloat(1.0), MaxAmount: decimal.NewFromFloat(5.0)}))
+		submitOrder(t, ob, Order{ID: 1, Price: decimal.NewFromFloat(3.0), Amount: decimal.NewFromFloat(4.0), Type: LimitOrderType, Dir: SellOrderDirection})
+		submitOrder(t, ob, Order{ID: 2, Price: decimal.NewFromFloat(4.0), Amount: decimal.NewFromFloat(5.0), Type: LimitOrderType, Dir: SellOrderDirection})
+
+		tr, err := ob.SubmitOrder(&Order{ID: 3, Price: decimal.NewFromFloat(1.0), Notional: decimal.NewFromFloat(10.0), Type: MarketOrderType, Dir: BuyOrderDirection})
+		require.NoError(t, err)
+		trades, err := tr.Commit()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(trades))
+		require.True(t, decimal.NewFromFloat(3.0).Equal(trades[0].Amount))
+		require.True(t, decimal.NewFromFloat(1.0).Equal(tr.Cancelled()[0].Amount))
+		require.True(t, decimal.NewFromFloat(1.0).Equal(ob.sell.index[1].Value.(*Order).Amount))
+
+		// stops at the maximum amount
+		trades = submitTrades(t, ob, Order{ID: 4, Price: decimal.NewFromFloat(1.0), Notional: decimal.NewFromFloat(100.0), Type: MarketOrderType, Dir: BuyOrderDirection})
+		require.Equal(t, 2, len(trades))
+		require.True(t, decimal.NewFromFloat(1.0).Equal(trades[0].Amount))
+		require.True(t, decimal.NewFromFloat(4.0).Equal(trades[1].Amount))
+		require.True(t, decimal.NewFromFloat(1.0).Equal(ob.sell.TotalVolume()))
+	})
+
+	t.Run("amend", func(t *testing.T) {
+		ob := NewOrderBook(WithInstrument(spec))
+		submitOrder(t, ob, *limit("10", "2"))
+
+		_, err := ob.AmendOrder(1, decimal.RequireFromString("10.01"), decimal.NewFromFloat(2.0))
+		require.ErrorIs(t, err, ErrOffTickPrice)
+		_, err = ob.AmendOrder(1, decimal.NewFromFloat(10.0), decimal.RequireFromString("0.2"))
+		require.ErrorIs(t, err, ErrMinAmount)
+		_, err = ob.AmendOrder(1, decimal.NewFromFloat(10.0), decimal.NewFromFloat(1.0))
+		require.NoError(t, err)
+	})
+
+	t.Run("exchange", func(t *testing.T) {
+		e := NewExchange()
+		_, err := e.AddInstrument("BTC-USD", WithInstrument(spec))
+		require.NoError(t, err)
+		_, err = e.SubmitOrder("BTC-USD", limit("10.03", "1.5"))
+		require.ErrorIs(t, err, ErrOffTickPrice)
+	})
+}
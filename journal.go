@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/shopspring/decimal"
+)
+
+type CommandType uint8
+
+const (
+	SubmitCommandType CommandType = iota
+	CancelCommandType
+	AmendCommandType
+	ExpireCommandType
+	ConfigCommandType // journal header, options of the journaled book
+)
+
+// Command is a journal entry, Timestamp is the book time the command has been executed at
+type Command struct {
+	Seq       uint64               `json:"seq"`
+	Type      CommandType          `json:"type"`
+	Timestamp MillisecondTimestamp `json:"timestamp"`
+	Order     *Order               `json:"order,omitempty"`
+	ID        OrderID              `json:"id,omitempty"`
+	Price     decimal.Decimal      `json:"price"`
+	Amount    decimal.Decimal      `json:"amount"`
+	ExpireAt  MillisecondTimestamp `json:"expire_at,omitempty"`
+	Config    *BookConfig          `json:"config,omitempty"`
+}
+
+// BookConfig holds the book options which change how commands are matched, Replay applies them before the commands
+type BookConfig struct {
+	TickSize       decimal.Decimal `json:"tick_size"`
+	Instrument     Instrument      `json:"instrument"`
+	AmountPlaces   int32           `json:"amount_places"`
+	AmountRounding RoundingMode    `json:"amount_rounding"`
+	ChecksumLevels int             `json:"checksum_levels"`
+}
+
+func (ob *OrderBook) config() *BookConfig {
+	return &BookConfig{
+		TickSize:       ob.tickSize,
+		Instrument:     ob.instrument,
+		AmountPlaces:   ob.buy.rounding.places,
+		AmountRounding: ob.buy.rounding.mode,
+		ChecksumLevels: ob.checksumLevels,
+	}
+}
+
+// options recreate the config, the tick size goes last as it may differ from the instrument one
+func (c *BookConfig) options() []OrderBookOption {
+	return []OrderBookOption{
+		WithInstrument(c.Instrument),
+		WithTickSize(c.TickSize),
+		WithAmountRounding(c.AmountPlaces, c.AmountRounding),
+		WithChecksumLevels(c.ChecksumLevels),
+	}
+}
+
+// Journal owns an OrderBook and appends every command to the journal before its transaction is committed.
+// The book clock is frozen at the command time while the command runs, so a replay stamps orders and trades the same way.
+// Every journal starts with a header holding the book config
+type Journal struct {
+	book   *OrderBook
+	clock  Clock
+	now    *ManualClock
+	w      io.Writer
+	seq    uint64
+	header bool // written to w
+}
+
+// NewJournal creates a journaled book, clock is the source of command times and replaces any WithClock option
+func NewJournal(w io.Writer, clock Clock, opts ...OrderBookOption) *Journal {
+	now := NewManualClock(clock.Now())
+	return &Journal{
+		book:  NewOrderBook(append(opts, WithClock(now))...),
+		clock: clock,
+		now:   now,
+		w:     w,
+	}
+}
+
+// Book returns the journaled book for reads, commands sent to it directly are not journaled
+// and make commands evaluated meanwhile fail with ErrStaleTransaction
+func (j *Journal) Book() *OrderBook {
+	return j.book
+}
+
+func (j *Journal) SubmitOrder(order *Order) (Transaction, error) {
+	cmd := Command{Type: SubmitCommandType, Order: new(Order)}
+	*cmd.Order = *order // as received, before the book stamps and fills it
+	return j.run(cmd, func() (Transaction, error) {
+		return j.book.SubmitOrder(order)
+	})
+}
+
+func (j *Journal) CancelOrder(id OrderID) (Transaction, error) {
+	return j.run(Command{Type: CancelCommandType, ID: id}, func() (Transaction, error) {
+		return j.book.CancelOrder(id)
+	})
+}
+
+func (j *Journal) AmendOrder(id OrderID, price, amount decimal.Decimal) (Transaction, error) {
+	return j.run(Command{Type: AmendCommandType, ID: id, Price: price, Amount: amount}, func() (Transaction, error) {
+		return j.book.AmendOrder(id, price, amount)
+	})
+}
+
+func (j *Journal) ExpireOrders(now MillisecondTimestamp) (Transaction, error) {
+	return j.run(Command{Type: ExpireCommandType, ExpireAt: now}, func() (Transaction, error) {
+		return j.book.ExpireOrders(now)
+	})
+}
+
+// Checkpoint writes a snapshot of the book and continues the journal in next,
+// the book is restored later from the snapshot and the journal written to next
+func (j *Journal) Checkpoint(snapshot io.Writer, next io.Writer) error {
+	if err := j.book.SnapshotBinary(snapshot); err != nil {
+		return err
+	}
+	j.w = next
+	j.header = false
+	return nil
+}
+
+// run journals a command which has been evaluated successfully and commits its transaction
+func (j *Journal) run(cmd Command, fn func() (Transaction, error)) (Transaction, error) {
+	cmd.Timestamp = j.clock.Now()
+	j.now.Set(cmd.Timestamp)
+
+	tr, err := fn()
+	if err != nil {
+		return Transaction{}, err
+	}
+	// only commands the book is going to take are journaled
+	if err := tr.check(); err != nil {
+		return Transaction{}, err
+	}
+
+	cmd.Seq = j.seq + 1
+	if err := j.append(cmd); err != nil {
+		tr.Rollback()
+		return Transaction{}, err
+	}
+	j.seq = cmd.Seq
+
+	if _, err := tr.Commit(); err != nil {
+		return Transaction{}, err
+	}
+	return tr, nil
+}
+
+func (j *Journal) append(cmd Command) error {
+	enc := json.NewEncoder(j.w)
+	if !j.header {
+		if err := enc.Encode(Command{Type: ConfigCommandType, Config: j.book.config()}); err != nil {
+			return err
+		}
+		j.header = true
+	}
+	if err := enc.Encode(cmd); err != nil {
+		return err
+	}
+	if s, ok := j.w.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+// Replay rebuilds a book from an optional snapshot and the journal written after it,
+// it returns trades of the replayed commands. A torn last entry is ignored, it has never been committed.
+// The book is created with the config from the journal header followed by opts
+func Replay(snapshot, journal io.Reader, opts ...OrderBookOption) (*OrderBook, []Trade, error) {
+	dec := json.NewDecoder(bufio.NewReader(journal))
+	next := func() (*Command, error) {
+		var cmd Command
+		err := dec.Decode(&cmd)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &cmd, nil
+	}
+
+	cmd, err := next()
+	if err != nil {
+		return nil, nil, err
+	}
+	if cmd != nil && cmd.Type == ConfigCommandType {
+		if cmd.Config == nil {
+			return nil, nil, ErrBadCommand
+		}
+		opts = append(cmd.Config.options(), opts...)
+		if cmd, err = next(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	clock := NewManualClock(0)
+	ob := NewOrderBook(append(opts, WithClock(clock))...)
+	if snapshot != nil {
+		if err := ob.Restore(snapshot); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	trades := make([]Trade, 0)
+	var seq uint64
+	for cmd != nil {
+		if cmd.Type == ConfigCommandType {
+			return nil, nil, ErrBadCommand
+		}
+		if seq != 0 && cmd.Seq != seq+1 {
+			return nil, nil, ErrJournalGap
+		}
+		seq = cmd.Seq
+
+		clock.Set(cmd.Timestamp)
+		tr, err := ob.replay(*cmd)
+		if err != nil {
+			return nil, nil, err
+		}
+		t, err := tr.Commit()
+		if err != nil {
+			return nil, nil, err
+		}
+		trades = append(trades, t...)
+
+		if cmd, err = next(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return ob, trades, nil
+}
+
+func (ob *OrderBook) replay(cmd Command) (Transaction, error) {
+	switch cmd.Type {
+	case SubmitCommandType:
+		if cmd.Order == nil {
+			return Transaction{}, ErrBadCommand
+		}
+		return ob.SubmitOrder(cmd.Order)
+	case CancelCommandType:
+		return ob.CancelOrder(cmd.ID)
+	case AmendCommandType:
+		return ob.AmendOrder(cmd.ID, cmd.Price, cmd.Amount)
+	case ExpireCommandType:
+		return ob.ExpireOrders(cmd.ExpireAt)
+	}
+	return Transaction{}, ErrBadCommand
+}
+
+// EncodeTrades writes trades as JSON lines
+func EncodeTrades(w io.Writer, trades []Trade) error {
+	enc := json.NewEncoder(w)
+	for _, t := range trades {
+		if err := enc.Encode(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	ErrBadCommand = errors.New("bad journal command")
+	ErrJournalGap = errors.New("journal commands are not consecutive")
+)
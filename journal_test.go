@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal(t *testing.T) {
+	orders := []Order{
+		{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
+		{ID: 2, Price: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(100.0), Display: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: SellOrderDirection},
+		{ID: 3, Price: decimal.NewFromFloat(9.0), Amount: decimal.NewFromFloat(50.0), Type: LimitOrderType, Dir: BuyOrderDirection, TimeInForce: GTDTimeInForce, ExpireAt: 10_000},
+		{ID: 4, Price: decimal.NewFromFloat(12.0), StopPrice: decimal.NewFromFloat(10.5), Amount: decimal.NewFromFloat(5.0), Type: StopLimitOrderType, Dir: BuyOrderDirection},
+		{ID: 5, Price: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(120.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+		{ID: 6, Price: decimal.NewFromFloat(1.0), Notional: decimal.NewFromFloat(55.0), Type: MarketOrderType, Dir: BuyOrderDirection},
+	}
+
+	// run submits orders, amends, cancels and expires through the journal and returns live trades
+	run := func(t *testing.T, j *Journal, clock *ManualClock) []Trade {
+		trades := make([]Trade, 0)
+		for _, v := range orders {
+			v := v
+			clock.Advance(time.Second)
+			tr, err := j.SubmitOrder(&v)
+			require.NoError(t, err)
+			trades = append(trades, tr.Trades()...)
+		}
+
+		clock.Advance(time.Second)
+		tr, err := j.AmendOrder(2, decimal.NewFromFloat(11.5), decimal.NewFromFloat(60.0))
+		require.NoError(t, err)
+		trades = append(trades, tr.Trades()...)
+
+		_, err = j.CancelOrder(99)
+		require.ErrorIs(t, err, ErrOrderNotFound)
+
+		clock.Advance(time.Second)
+		_, err = j.ExpireOrders(clock.Now())
+		require.NoError(t, err)
+
+		clock.Advance(time.Second)
+		tr, err = j.SubmitOrder(&Order{ID: 7, Price: decimal.NewFromFloat(1.0), Amount: decimal.NewFromFloat(30.0), Type: MarketOrderType, Dir: BuyOrderDirection})
+		require.NoError(t, err)
+		trades = append(trades, tr.Trades()...)
+		return trades
+	}
+
+	encode := func(t *testing.T, trades []Trade) []byte {
+		var buf bytes.Buffer
+		require.NoError(t, EncodeTrades(&buf, trades))
+		return buf.Bytes()
+	}
+
+	t.Run("replay is identical", func(t *testing.T) {
+		var journal bytes.Buffer
+		clock := NewManualClock(0)
+		j := NewJournal(&journal, clock)
+		trades := run(t, j, clock)
+		require.Greater(t, len(trades), 3)
+
+		ob, replayed, err := Replay(nil, bytes.NewReader(journal.Bytes()))
+		require.NoError(t, err)
+		require.Equal(t, encode(t, trades), encode(t, replayed))
+		require.Equal(t, j.Book().Checksum(), ob.Checksum())
+		require.Equal(t, j.Book().lastTradeID, ob.lastTradeID)
+	})
+
+	t.Run("journaled orders are kept as received", func(t *testing.T) {
+		var journal bytes.Buffer
+		j := NewJournal(&journal, NewManualClock(5))
+		o := Order{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Display: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: SellOrderDirection}
+		_, err := j.SubmitOrder(&o)
+		require.NoError(t, err)
+		require.Equal(t, MillisecondTimestamp(5), o.Timestamp)
+		require.True(t, strings.Contains(journal.String(), `"timestamp":0,`))
+		require.False(t, strings.Contains(journal.String(), `"tip"`))
+	})
+
+	t.Run("failed commands are not journaled", func(t *testing.T) {
+		var journal bytes.Buffer
+		j := NewJournal(&journal, NewManualClock(0))
+		_, err := j.SubmitOrder(&Order{ID: 1, Price: decimal.NewFromFloat(-1.0), Amount: decimal.NewFromFloat(1.0), Type: LimitOrderType, Dir: SellOrderDirection})
+		require.ErrorIs(t, err, ErrBadPrice)
+		require.Equal(t, 0, journal.Len())
+	})
+
+	t.Run("stale commands are not journaled", func(t *testing.T) {
+		var journal bytes.Buffer
+		j := NewJournal(&journal, NewManualClock(0))
+		_, err := j.run(Command{Type: ExpireCommandType}, func() (Transaction, error) {
+			tr, err := j.book.ExpireOrders(0)
+			j.book.invalidate()
+			return tr, err
+		})
+		require.ErrorIs(t, err, ErrStaleTransaction)
+		require.Equal(t, 0, journal.Len())
+	})
+
+	t.Run("checkpoint", func(t *testing.T) {
+		var first, snapshot, second bytes.Buffer
+		clock := NewManualClock(0)
+		j := NewJournal(&first, clock)
+		for _, v := range orders[:3] {
+			v := v
+			_, err := j.SubmitOrder(&v)
+			require.NoError(t, err)
+		}
+		require.NoError(t, j.Checkpoint(&snapshot, &second))
+
+		trades := make([]Trade, 0)
+		for _, v := range orders[3:] {
+			v := v
+			clock.Advance(time.Second)
+			tr, err := j.SubmitOrder(&v)
+			require.NoError(t, err)
+			trades = append(trades, tr.Trades()...)
+		}
+
+		ob, replayed, err := Replay(&snapshot, &second)
+		require.NoError(t, err)
+		require.Equal(t, encode(t, trades), encode(t, replayed))
+		require.Equal(t, j.Book().Checksum(), ob.Checksum())
+	})
+
+	t.Run("torn tail and gaps", func(t *testing.T) {
+		var journal bytes.Buffer
+		clock := NewManualClock(0)
+		run(t, NewJournal(&journal, clock), clock)
+		lines := strings.SplitAfter(journal.String(), "\n")
+
+		require.True(t, strings.Contains(lines[0], `"config":`))
+
+		// header and the first three commands
+		torn := strings.Join(lines[:4], "") + lines[4][:10]
+		_, trades, err := Replay(nil, strings.NewReader(torn))
+		require.NoError(t, err)
+		require.Equal(t, 0, len(trades))
+
+		gap := lines[0] + lines[1] + lines[3]
+		_, _, err = Replay(nil, strings.NewReader(gap))
+		require.ErrorIs(t, err, ErrJournalGap)
+	})
+
+	t.Run("replay tool", func(t *testing.T) {
+		dir := t.TempDir()
+		f, err := os.Create(filepath.Join(dir, "journal"))
+		require.NoError(t, err)
+
+		clock := NewManualClock(0)
+		trades := run(t, NewJournal(f, clock), clock)
+		require.NoError(t, f.Close())
+
+		var out bytes.Buffer
+		require.NoError(t, replay("", filepath.Join(dir, "journal"), &out))
+		require.Equal(t, encode(t, trades), out.Bytes())
+	})
+
+	t.Run("replay keeps the book config", func(t *testing.T) {
+		dir := t.TempDir()
+		f, err := os.Create(filepath.Join(dir, "journal"))
+		require.NoError(t, err)
+
+		clock := NewManualClock(0)
+		j := NewJournal(f, clock, WithInstrument(Instrument{TickSize: decimal.NewFromFloat(1.0)}), WithAmountRounding(1, UpRoundingMode))
+		trades := make([]Trade, 0)
+		for _, v := range []Order{
+			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			// repriced to 9 by the instrument tick
+			{ID: 2, Price: decimal.NewFromFloat(12.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection, PostOnly: RepricePostOnly},
+			{ID: 3, Price: decimal.NewFromFloat(1.0), Amount: decimal.NewFromFloat(5.0), Type: MarketOrderType, Dir: SellOrderDirection},
+			{ID: 4, Price: decimal.NewFromFloat(1.0), Notional: decimal.NewFromFloat(33.0), Type: MarketOrderType, Dir: BuyOrderDirection},
+		} {
+			v := v
+			clock.Advance(time.Second)
+			tr, err := j.SubmitOrder(&v)
+			require.NoError(t, err)
+			trades = append(trades, tr.Trades()...)
+		}
+		require.NoError(t, f.Close())
+		require.Equal(t, 2, len(trades))
+		require.True(t, decimal.NewFromFloat(9.0).Equal(trades[0].Price))
+		require.True(t, decimal.RequireFromString("3.3").Equal(trades[1].Amount))
+
+		var out bytes.Buffer
+		require.NoError(t, replay("", filepath.Join(dir, "journal"), &out))
+		require.Equal(t, encode(t, trades), out.Bytes())
+
+		journal, err := os.Open(filepath.Join(dir, "journal"))
+		require.NoError(t, err)
+		defer journal.Close()
+		ob, _, err := Replay(nil, journal)
+		require.NoError(t, err)
+		require.Equal(t, j.Book().Checksum(), ob.Checksum())
+		require.True(t, decimal.NewFromFloat(1.0).Equal(ob.Instrument().TickSize))
+	})
+
+	t.Run("config is only a header", func(t *testing.T) {
+		var journal bytes.Buffer
+		clock := NewManualClock(0)
+		run(t, NewJournal(&journal, clock), clock)
+		lines := strings.SplitAfter(journal.String(), "\n")
+
+		_, _, err := Replay(nil, strings.NewReader(lines[1]+lines[0]))
+		require.ErrorIs(t, err, ErrBadCommand)
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// replays a command journal on top of an optional snapshot and prints resulting trades as JSON lines
+func main() {
+	snapshot := flag.String("snapshot", "", "book snapshot to start from")
+	journal := flag.String("journal", "", "command journal written after the snapshot")
+	flag.Parse()
+
+	if err := replay(*snapshot, *journal, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func replay(snapshotPath, journalPath string, w io.Writer) error {
+	if journalPath == "" {
+		return fmt.Errorf("journal is required")
+	}
+
+	var snapshot io.Reader
+	if snapshotPath != "" {
+		f, err := os.Open(snapshotPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		snapshot = f
+	}
+
+	journal, err := os.Open(journalPath)
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+
+	_, trades, err := Replay(snapshot, journal)
+	if err != nil {
+		return err
+	}
+	return EncodeTrades(w, trades)
+}
@@ -4,6 +4,7 @@ import (
 	"container/list"
 	"errors"
 	"fmt"
+	"sort"
 
 	rbtree "github.com/emirpasic/gods/trees/redblacktree"
 	"github.com/shopspring/decimal"
@@ -17,6 +18,8 @@ type OrderType uint8
 const (
 	MarketOrderType OrderType = iota
 	LimitOrderType
+	StopOrderType      // becomes market order once Order.StopPrice is reached
+	StopLimitOrderType // becomes limit order once Order.StopPrice is reached
 )
 
 type OrderDirection uint8
@@ -26,33 +29,164 @@ const (
 	SellOrderDirection
 )
 
+type TimeInForce uint8
+
+const (
+	GTCTimeInForce TimeInForce = iota // good till cancel
+	IOCTimeInForce                    // immediate or cancel
+	FOKTimeInForce                    // fill or kill
+	GTDTimeInForce                    // good till date, Order.ExpireAt
+	DayTimeInForce                    // good till end of the UTC day by the book clock, Order.ExpireAt may only bring it forward
+)
+
+const tradingDay = MillisecondTimestamp(24 * 60 * 60 * 1000)
+
+type STPMode uint8
+
+const (
+	NoSTPMode           STPMode = iota
+	CancelNewestSTPMode         // cancel the incoming order
+	CancelOldestSTPMode         // cancel the resting order
+	CancelBothSTPMode           // cancel both orders
+	DecrementSTPMode            // decrease both orders by the smaller amount, cancel the one left empty
+)
+
+type SlippageType uint8
+
+const (
+	TickSlippageType    SlippageType = iota // Order.MaxSlippage in ticks
+	PercentSlippageType                     // Order.MaxSlippage in percent of the best price
+)
+
+type RoundingMode uint8
+
+const (
+	DownRoundingMode     RoundingMode = iota // towards zero
+	UpRoundingMode                           // away from zero
+	HalfUpRoundingMode                       // to nearest, half away from zero
+	HalfEvenRoundingMode                     // to nearest, half to even
+)
+
+type rounding struct {
+	places int32
+	mode   RoundingMode
+}
+
+func (r rounding) apply(d decimal.Decimal) decimal.Decimal {
+	switch r.mode {
+	case UpRoundingMode:
+		return d.RoundUp(r.places)
+	case HalfUpRoundingMode:
+		return d.Round(r.places)
+	case HalfEvenRoundingMode:
+		return d.RoundBank(r.places)
+	}
+	return d.RoundDown(r.places)
+}
+
+type PostOnly uint8
+
+const (
+	NoPostOnly      PostOnly = iota
+	RejectPostOnly           // reject order which would take liquidity
+	RepricePostOnly          // move order one tick behind the opposite best price
+)
+
 type Order struct {
-	Amount decimal.Decimal `json:"amount"`
-	Price  decimal.Decimal `json:"price"`
-	//Timestamp MillisecondTimestamp `json:"timestamp"`
-	ID   OrderID        `json:"id"`
-	Type OrderType      `json:"type"`
-	Dir  OrderDirection `json:"dir"`
+	Amount    decimal.Decimal `json:"amount"`
+	Price     decimal.Decimal `json:"price"`
+	StopPrice decimal.Decimal `json:"stop_price"`
+	Display   decimal.Decimal `json:"display"` // iceberg tip size, the rest of Amount is hidden
+
+	// currently displayed part of a resting iceberg, owned by the book
+	tip decimal.Decimal
+
+	// market order spends Notional in quote currency instead of trading Amount
+	Notional decimal.Decimal `json:"notional"`
+
+	// market order stops sweeping the book at Protection price or MaxSlippage away from the best price
+	Protection   decimal.Decimal `json:"protection"`
+	MaxSlippage  decimal.Decimal `json:"max_slippage"`
+	SlippageType SlippageType    `json:"slippage_type"`
+
+	Timestamp   MillisecondTimestamp `json:"timestamp"`
+	ExpireAt    MillisecondTimestamp `json:"expire_at,omitempty"`
+	ID          OrderID              `json:"id"`
+	Type        OrderType            `json:"type"`
+	Dir         OrderDirection       `json:"dir"`
+	TimeInForce TimeInForce          `json:"tif"`
+	PostOnly    PostOnly             `json:"post_only"`
+	Owner       string               `json:"owner,omitempty"`
+	STP         STPMode              `json:"stp"`
+}
+
+func (o *Order) expirable() bool {
+	return o.TimeInForce == GTDTimeInForce || o.TimeInForce == DayTimeInForce
+}
+
+func (o *Order) selfTrade(maker *Order) bool {
+	return o.STP != NoSTPMode && o.Owner != "" && o.Owner == maker.Owner
+}
+
+func (o *Order) quote() bool {
+	return o.Notional.Sign() > 0
+}
+
+func (o *Order) iceberg() bool {
+	return o.Display.Sign() > 0
+}
+
+func (o *Order) visible() decimal.Decimal {
+	if o.iceberg() {
+		return o.tip
+	}
+	return o.Amount
 }
 
 type priceKey = string
 type finalizerFn func()
 
 type OrderContainer struct {
-	priceTree *rbtree.Tree // [Order.Price]*OrderQueue
+	priceOf   func(*Order) decimal.Decimal
+	priceTree *rbtree.Tree // [priceOf(Order)]*OrderQueue
 	priceHash map[priceKey]*OrderQueue
+	index     map[OrderID]*list.Element
+	expiring  map[OrderID]*list.Element
 	volume    decimal.Decimal
+	visible   decimal.Decimal
+	rounding  rounding   // amounts of quote orders
+	spec      Instrument // lot size, precision and maximum of quote order amounts
+	changes   map[priceKey]levelChange
+	undo      *containerUndo // levels changed by a batch being applied
 }
 
 func newOrderContainer() *OrderContainer {
 	const defaultMapSize = 1024 * 1024
 
+	return newContainer(func(o *Order) decimal.Decimal {
+		return o.Price
+	}, defaultMapSize)
+}
+
+// stop orders are kept by Order.StopPrice until triggered
+func newStopContainer() *OrderContainer {
+	return newContainer(func(o *Order) decimal.Decimal {
+		return o.StopPrice
+	}, 0)
+}
+
+func newContainer(priceOf func(*Order) decimal.Decimal, size int) *OrderContainer {
 	return &OrderContainer{
+		priceOf: priceOf,
 		priceTree: rbtree.NewWith(func(a, b any) int {
 			return a.(decimal.Decimal).Cmp(b.(decimal.Decimal))
 		}),
-		priceHash: make(map[priceKey]*OrderQueue, defaultMapSize),
+		priceHash: make(map[priceKey]*OrderQueue, size),
+		index:     make(map[OrderID]*list.Element),
+		expiring:  make(map[OrderID]*list.Element),
 		volume:    decimal.Zero,
+		visible:   decimal.Zero,
+		rounding:  rounding{places: int32(decimal.DivisionPrecision), mode: DownRoundingMode},
 	}
 }
 
@@ -64,21 +198,33 @@ func (oc *OrderContainer) Debug() {
 	}
 }
 
+// Volume returns displayed volume, hidden iceberg amounts are excluded
 func (oc *OrderContainer) Volume() decimal.Decimal {
+	return oc.visible
+}
+
+func (oc *OrderContainer) TotalVolume() decimal.Decimal {
 	return oc.volume
 }
 
 func (oc *OrderContainer) Add(order *Order) error {
-	priceKey := order.Price.String()
+	price := oc.priceOf(order)
+	oc.touch(price)
+	priceKey := price.String()
 	queue, ok := oc.priceHash[priceKey]
 	if !ok {
-		queue = newOrderQueue(order.Price)
+		queue = newOrderQueue(price)
 		oc.priceHash[priceKey] = queue
-		oc.priceTree.Put(order.Price, queue)
+		oc.priceTree.Put(price, queue)
 	}
 
-	queue.Add(order)
-	oc.volume.Add(order.Amount)
+	el := queue.Add(order)
+	oc.index[order.ID] = el
+	if order.expirable() {
+		oc.expiring[order.ID] = el
+	}
+	oc.volume = oc.volume.Add(order.Amount)
+	oc.visible = oc.visible.Add(order.visible())
 
 	return nil
 }
@@ -89,14 +235,87 @@ func (oc *OrderContainer) Remove(price decimal.Decimal) error {
 	if !ok {
 		return nil
 	}
+	oc.touch(price)
 	delete(oc.priceHash, priceKey)
 
+	for el := queue.orders.Front(); el != nil; el = el.Next() {
+		oc.unindex(el.Value.(*Order).ID)
+	}
+
 	oc.priceTree.Remove(price)
-	oc.volume = oc.volume.Sub(queue.Volume())
+	oc.volume = oc.volume.Sub(queue.TotalVolume())
+	oc.visible = oc.visible.Sub(queue.Volume())
 
 	return nil
 }
 
+func (oc *OrderContainer) find(id OrderID) (*list.Element, bool) {
+	el, ok := oc.index[id]
+	return el, ok
+}
+
+func (oc *OrderContainer) unindex(id OrderID) {
+	delete(oc.index, id)
+	delete(oc.expiring, id)
+}
+
+// expired returns resting orders expiring at or before now, earliest first
+func (oc *OrderContainer) expired(now MillisecondTimestamp) []*list.Element {
+	els := make([]*list.Element, 0)
+	for _, el := range oc.expiring {
+		if el.Value.(*Order).ExpireAt <= now {
+			els = append(els, el)
+		}
+	}
+
+	sort.Slice(els, func(i, j int) bool {
+		a, b := els[i].Value.(*Order), els[j].Value.(*Order)
+		if a.ExpireAt != b.ExpireAt {
+			return a.ExpireAt < b.ExpireAt
+		}
+		return a.ID < b.ID
+	})
+	return els
+}
+
+// removeOrder drops a single resting order, the price level goes away with its last order
+func (oc *OrderContainer) removeOrder(el *list.Element) {
+	order := el.Value.(*Order)
+	priceKey := oc.priceOf(order).String()
+	queue := oc.priceHash[priceKey]
+	oc.touch(queue.Price())
+
+	queue.Remove(el)
+	oc.unindex(order.ID)
+	oc.volume = oc.volume.Sub(order.Amount)
+	oc.visible = oc.visible.Sub(order.visible())
+
+	if queue.orders.Len() == 0 {
+		delete(oc.priceHash, priceKey)
+		oc.priceTree.Remove(queue.Price())
+	}
+}
+
+func (oc *OrderContainer) reduce(el *list.Element, amount decimal.Decimal) {
+	order := el.Value.(*Order)
+	queue := oc.priceHash[oc.priceOf(order).String()]
+	oc.touch(queue.Price())
+
+	visible := queue.Volume()
+	oc.volume = oc.volume.Sub(order.Amount.Sub(amount))
+	queue.update(order, amount)
+	oc.visible = oc.visible.Sub(visible.Sub(queue.Volume()))
+}
+
+// processed updates container bookkeeping after a queue finalizer has been applied
+func (oc *OrderContainer) processed(done []*Order, amount, visible decimal.Decimal) {
+	for _, o := range done {
+		oc.unindex(o.ID)
+	}
+	oc.volume = oc.volume.Sub(amount)
+	oc.visible = oc.visible.Sub(visible)
+}
+
 func nextMinNode(cur *rbtree.Node) *rbtree.Node {
 	if right := cur.Right; right != nil {
 		n := right.Left
@@ -155,99 +374,135 @@ func nextMaxNode(cur *rbtree.Node) *rbtree.Node {
 	return nil
 }
 
-func (oc *OrderContainer) matchMinPrice(order *Order, stopPrice *decimal.Decimal) ([]*Order, decimal.Decimal, finalizerFn) {
-	orders := make([]*Order, 0)
-	finalizers := make([]finalizerFn, 0)
-	amountLeft := order.Amount
-
-	node := oc.priceTree.Left()
-	for node != nil {
-		queue := node.Value.(*OrderQueue)
-		if stopPrice != nil && queue.Price().GreaterThan(*stopPrice) {
-			break
-		}
-
-		done, left, finalizer := queue.Process(order, amountLeft)
-		if order.Amount.GreaterThanOrEqual(queue.Volume()) {
-			// here we can skip queue finalizer, because all of queue orders have been fully processed
-			// GC will free it
-			finalizers = append(finalizers, func() {
-				oc.Remove(queue.Price())
-			})
-		} else {
-			finalizers = append(finalizers, func() {
-				finalizer()
-			})
-		}
+// matching is an outcome of processing an order, the book is not changed until finalize is called
+type matching struct {
+	orders    []*Order // fully processed orders
+	trades    []Trade
+	prevented []SelfTrade
+	cancelled []Cancellation
+	left      decimal.Decimal
+	finalize  finalizerFn
 
-		orders = append(orders, done...)
+	takerCancelled bool // remainder has been cancelled by self-trade prevention
+}
 
-		amountLeft = left
-		if left.Equal(decimal.Zero) {
-			break
-		}
-		node = nextMinNode(node)
+func newMatching(amount decimal.Decimal) matching {
+	return matching{
+		orders:    make([]*Order, 0),
+		trades:    make([]Trade, 0),
+		prevented: make([]SelfTrade, 0),
+		left:      amount,
+		finalize:  func() {},
 	}
+}
 
-	return orders, amountLeft, func() {
-		for _, fn := range finalizers {
-			fn()
-		}
+// cancel drops unfilled remainder of the incoming order instead of resting it
+func (m *matching) cancel(order *Order) {
+	m.orders = append(m.orders, order)
+	if m.left.Sign() > 0 {
+		m.cancelled = append(m.cancelled, Cancellation{Amount: m.left, ID: order.ID})
 	}
 }
 
-func (oc *OrderContainer) matchMaxPrice(order *Order, stopPrice *decimal.Decimal) ([]*Order, decimal.Decimal, finalizerFn) {
-	orders := make([]*Order, 0)
+func (oc *OrderContainer) matchMinPrice(order *Order, stopPrice *decimal.Decimal, dry bool) matching {
+	return oc.match(order, oc.priceTree.Left(), nextMinNode, func(price decimal.Decimal) bool {
+		return stopPrice != nil && price.GreaterThan(*stopPrice)
+	}, dry)
+}
+
+func (oc *OrderContainer) matchMaxPrice(order *Order, stopPrice *decimal.Decimal, dry bool) matching {
+	return oc.match(order, oc.priceTree.Right(), nextMaxNode, func(price decimal.Decimal) bool {
+		return stopPrice != nil && price.LessThan(*stopPrice)
+	}, dry)
+}
+
+// match sweeps price levels starting from node, for quote orders matching.left is the notional left to spend.
+// A dry match builds no finalizers and can not be committed
+func (oc *OrderContainer) match(order *Order, node *rbtree.Node, next func(*rbtree.Node) *rbtree.Node, stop func(decimal.Decimal) bool, dry bool) matching {
+	m := newMatching(order.Amount)
+	if order.quote() {
+		m.left = order.Notional
+	}
 	finalizers := make([]finalizerFn, 0)
-	amountLeft := order.Amount
+	filled := decimal.Zero
 
-	node := oc.priceTree.Right()
 	for node != nil {
 		queue := node.Value.(*OrderQueue)
-		if stopPrice != nil && queue.Price().LessThan(*stopPrice) {
+		if stop(queue.Price()) {
 			break
 		}
 
-		done, left, finalizer := queue.Process(order, amountLeft)
-		if order.Amount.GreaterThanOrEqual(queue.Volume()) {
+		amount := m.left
+		if order.quote() {
+			amount = oc.spec.quoteAmount(oc.rounding.apply(m.left.Div(queue.Price())), filled)
+			if amount.Sign() <= 0 {
+				break
+			}
+		}
+
+		qm := queue.process(order, amount, dry)
+		switch {
+		case dry:
+		case len(qm.orders) == queue.orders.Len():
 			// here we can skip queue finalizer, because all of queue orders have been fully processed
 			// GC will free it
 			finalizers = append(finalizers, func() {
 				oc.Remove(queue.Price())
 			})
-		} else {
+		default:
 			finalizers = append(finalizers, func() {
-				finalizer()
+				oc.touch(queue.Price())
+				volume, visible := queue.TotalVolume(), queue.Volume()
+				qm.finalize()
+				oc.processed(qm.orders, volume.Sub(queue.TotalVolume()), visible.Sub(queue.Volume()))
 			})
 		}
 
-		orders = append(orders, done...)
+		m.orders = append(m.orders, qm.orders...)
+		m.trades = append(m.trades, qm.trades...)
+		m.prevented = append(m.prevented, qm.prevented...)
+		m.takerCancelled = qm.takerCancelled
+
+		if order.quote() {
+			filled = filled.Add(amount.Sub(qm.left))
+			m.left = m.left.Sub(amount.Sub(qm.left).Mul(queue.Price()))
+			if m.left.Sign() < 0 {
+				// rounded up past the notional
+				m.left = decimal.Zero
+			}
+		} else {
+			m.left = qm.left
+		}
 
-		amountLeft = left
-		if left.Equal(decimal.Zero) {
+		if m.left.IsZero() || m.takerCancelled {
 			break
 		}
-		node = nextMaxNode(node)
+		node = next(node)
 	}
 
-	return orders, amountLeft, func() {
-		for _, fn := range finalizers {
-			fn()
+	if !dry {
+		m.finalize = func() {
+			for _, fn := range finalizers {
+				fn()
+			}
 		}
 	}
+	return m
 }
 
 type OrderQueue struct {
-	orders *list.List
-	price  decimal.Decimal
-	volume decimal.Decimal
+	orders  *list.List
+	price   decimal.Decimal
+	volume  decimal.Decimal
+	visible decimal.Decimal
 }
 
 func newOrderQueue(price decimal.Decimal) *OrderQueue {
 	return &OrderQueue{
-		orders: list.New(),
-		price:  price,
-		volume: decimal.Zero,
+		orders:  list.New(),
+		price:   price,
+		volume:  decimal.Zero,
+		visible: decimal.Zero,
 	}
 }
 
@@ -264,73 +519,241 @@ func (oq *OrderQueue) Price() decimal.Decimal {
 	return oq.price
 }
 
+// Volume returns displayed volume, hidden iceberg amounts are excluded
 func (oq *OrderQueue) Volume() decimal.Decimal {
+	return oq.visible
+}
+
+func (oq *OrderQueue) TotalVolume() decimal.Decimal {
 	return oq.volume
 }
 
 func (oq *OrderQueue) Add(order *Order) *list.Element {
+	// the tip is never taken from the caller, every order entering the book shows a fresh one
+	var tip decimal.Decimal
+	if order.iceberg() {
+		tip = decimal.Min(order.Display, order.Amount)
+	}
+	order.tip = tip
+
 	el := oq.orders.PushBack(order)
 	oq.volume = oq.volume.Add(order.Amount)
+	oq.visible = oq.visible.Add(order.visible())
 	return el
 }
 
 func (oq *OrderQueue) Remove(el *list.Element) {
 	order := oq.orders.Remove(el).(*Order)
 	oq.volume = oq.volume.Sub(order.Amount)
+	oq.visible = oq.visible.Sub(order.visible())
 }
 
 func (oq *OrderQueue) update(order *Order, amount decimal.Decimal) {
+	visible := order.visible()
+	oq.volume = oq.volume.Sub(order.Amount.Sub(amount))
 	order.Amount = amount
+	if order.iceberg() {
+		order.tip = decimal.Min(order.tip, amount)
+	}
+	oq.visible = oq.visible.Sub(visible.Sub(order.visible()))
+}
+
+func (oq *OrderQueue) fill(order *Order, amount decimal.Decimal) {
 	oq.volume = oq.volume.Sub(amount)
+	oq.visible = oq.visible.Sub(amount)
+	order.Amount = order.Amount.Sub(amount)
+	if order.iceberg() {
+		order.tip = order.tip.Sub(amount)
+	}
+}
+
+// replenish shows the next iceberg tip, the order loses its time priority
+func (oq *OrderQueue) replenish(el *list.Element) {
+	order := el.Value.(*Order)
+	order.tip = decimal.Min(order.Display, order.Amount)
+	oq.visible = oq.visible.Add(order.tip)
+	oq.orders.MoveToBack(el)
+}
+
+func (oq *OrderQueue) Process(order *Order, amount decimal.Decimal) matching {
+	return oq.process(order, amount, false)
 }
 
-func (oq *OrderQueue) Process(order *Order, amount decimal.Decimal) ([]*Order, decimal.Decimal, finalizerFn) {
+func (oq *OrderQueue) process(order *Order, amount decimal.Decimal, dry bool) matching {
+	m := newMatching(amount)
 	if oq.orders.Len() == 0 {
-		return nil, decimal.Zero, func() {}
+		return m
+	}
+
+	type iceberg struct {
+		amount decimal.Decimal
+		tip    decimal.Decimal
 	}
 
-	devastated := make([]*Order, 0)
 	finalizers := make([]finalizerFn, 0)
 
-	amountLeft := amount
+	// replenished icebergs are matched again after the rest of the queue
+	requeued := make([]*list.Element, 0)
+	icebergs := make(map[*Order]iceberg)
+
 	el := oq.orders.Front()
 
-	for el != nil {
-		currOrder := el.Value.(*Order)
-		if amountLeft.LessThan(currOrder.Amount) {
-			amount := currOrder.Amount.Sub(amountLeft)
-			finalizers = append(finalizers, func() {
-				oq.update(currOrder, amount)
-			})
-			amountLeft = decimal.Zero
+	for m.left.Sign() > 0 && !m.takerCancelled {
+		currEl := el
+		if el != nil {
+			el = el.Next()
+		} else if len(requeued) > 0 {
+			currEl = requeued[0]
+			requeued = requeued[1:]
+		} else {
 			break
 		}
 
-		devastated = append(devastated, currOrder)
-		finalizers = append(finalizers, func() {
-			oq.Remove(el)
-		})
-		amountLeft = amountLeft.Sub(currOrder.Amount)
-		el = el.Next()
+		currOrder := currEl.Value.(*Order)
+		left, tip := currOrder.Amount, currOrder.visible()
+		if s, ok := icebergs[currOrder]; ok {
+			left, tip = s.amount, s.tip
+		}
+
+		if order.selfTrade(currOrder) {
+			amount := decimal.Min(m.left, left)
+			m.prevented = append(m.prevented, newSelfTrade(currOrder, order, amount))
+
+			cancelMaker := false
+			switch order.STP {
+			case CancelNewestSTPMode:
+				m.takerCancelled = true
+			case CancelOldestSTPMode:
+				cancelMaker = true
+			case CancelBothSTPMode:
+				cancelMaker = true
+				m.takerCancelled = true
+			case DecrementSTPMode:
+				cancelMaker = left.Equal(amount)
+				m.takerCancelled = m.left.Equal(amount)
+				if !cancelMaker {
+					rest := left.Sub(amount)
+					if !dry {
+						finalizers = append(finalizers, func() {
+							oq.update(currOrder, rest)
+						})
+					}
+				}
+				m.left = m.left.Sub(amount)
+			}
+
+			if cancelMaker {
+				m.orders = append(m.orders, currOrder)
+				if !dry {
+					finalizers = append(finalizers, func() {
+						oq.Remove(currEl)
+					})
+				}
+			}
+			continue
+		}
+
+		if m.left.LessThan(tip) {
+			filled := m.left
+			m.trades = append(m.trades, newTrade(currOrder, order, oq.Price(), filled))
+			if !dry {
+				finalizers = append(finalizers, func() {
+					oq.fill(currOrder, filled)
+				})
+			}
+			m.left = decimal.Zero
+			break
+		}
+
+		m.trades = append(m.trades, newTrade(currOrder, order, oq.Price(), tip))
+		m.left = m.left.Sub(tip)
+
+		if left.Equal(tip) {
+			m.orders = append(m.orders, currOrder)
+			if !dry {
+				finalizers = append(finalizers, func() {
+					oq.Remove(currEl)
+				})
+			}
+			continue
+		}
+
+		left = left.Sub(tip)
+		icebergs[currOrder] = iceberg{amount: left, tip: decimal.Min(currOrder.Display, left)}
+		requeued = append(requeued, currEl)
+
+		filled := tip
+		if !dry {
+			finalizers = append(finalizers, func() {
+				oq.fill(currOrder, filled)
+				oq.replenish(currEl)
+			})
+		}
 	}
 
-	return devastated, amountLeft, func() {
-		for _, fn := range finalizers {
-			fn()
+	if !dry {
+		m.finalize = func() {
+			for _, fn := range finalizers {
+				fn()
+			}
 		}
 	}
+	return m
 }
 
 type OrderBook struct {
 	buy  *OrderContainer
 	sell *OrderContainer
+
+	buyStops  *OrderContainer
+	sellStops *OrderContainer
+
+	lastTradeID TradeID
+	lastPrice   decimal.Decimal
+
+	// version changes with every commit, transactions evaluated against an older one are stale
+	version uint64
+
+	sequence       uint64
+	feed           func(MarketUpdate)
+	checksumLevels int
+
+	tickSize   decimal.Decimal
+	instrument Instrument
+	clock      Clock
+}
+
+type OrderBookOption func(*OrderBook)
+
+func WithTickSize(tick decimal.Decimal) OrderBookOption {
+	return func(ob *OrderBook) {
+		ob.tickSize = tick
+	}
+}
+
+// WithAmountRounding sets how amounts bought or sold for a quote notional are rounded
+func WithAmountRounding(places int32, mode RoundingMode) OrderBookOption {
+	return func(ob *OrderBook) {
+		r := rounding{places: places, mode: mode}
+		ob.buy.rounding = r
+		ob.sell.rounding = r
+	}
 }
 
-func NewOrderBook() *OrderBook {
-	return &OrderBook{
-		buy:  newOrderContainer(),
-		sell: newOrderContainer(),
+func NewOrderBook(opts ...OrderBookOption) *OrderBook {
+	ob := &OrderBook{
+		buy:       newOrderContainer(),
+		sell:      newOrderContainer(),
+		buyStops:  newStopContainer(),
+		sellStops: newStopContainer(),
+
+		checksumLevels: defaultChecksumLevels,
+		clock:          SystemClock{},
 	}
+	for _, opt := range opts {
+		opt(ob)
+	}
+	return ob
 }
 
 func (ob *OrderBook) Debug() {
@@ -341,100 +764,528 @@ func (ob *OrderBook) Debug() {
 }
 
 func (ob *OrderBook) SubmitOrder(order *Order) (Transaction, error) {
+	if err := validate(order); err != nil {
+		return Transaction{}, err
+	}
+	if err := ob.instrument.check(order); err != nil {
+		return Transaction{}, err
+	}
+	if _, _, ok := ob.find(order.ID); ok {
+		return Transaction{}, ErrDuplicateOrder
+	}
+	if _, _, ok := ob.findStop(order.ID); ok {
+		return Transaction{}, ErrDuplicateOrder
+	}
+	now := ob.clock.Now()
+	if err := expiry(order, now); err != nil {
+		return Transaction{}, err
+	}
+	order.Timestamp = now
+
+	switch order.Type {
+	case MarketOrderType:
+		return ob.matchMarketOrder(order)
+	case StopOrderType, StopLimitOrderType:
+		return ob.addStopOrder(order)
+	}
+
+	return ob.matchLimitOrder(order)
+}
+
+// validate checks order fields which do not depend on the book state
+func validate(order *Order) error {
 	if order.Price.Sign() <= 0 {
+		return ErrBadPrice
+	}
+	if order.Amount.Sign() < 0 || order.Display.Sign() < 0 {
+		return ErrBadAmount
+	}
+	if order.Notional.Sign() < 0 {
+		return ErrBadNotional
+	}
+	if order.Amount.IsZero() && !order.quote() {
+		return ErrBadAmount
+	}
+	if order.quote() && order.Type != MarketOrderType && order.Type != StopOrderType {
+		return ErrBadNotional
+	}
+	switch order.TimeInForce {
+	case GTCTimeInForce, IOCTimeInForce, FOKTimeInForce:
+	case GTDTimeInForce:
+		if order.ExpireAt <= 0 {
+			return ErrBadExpiry
+		}
+	case DayTimeInForce:
+		if order.ExpireAt < 0 {
+			return ErrBadExpiry
+		}
+	default:
+		return ErrBadTimeInForce
+	}
+	return nil
+}
+
+// expiry sets DAY orders to expire at the end of the current day and rejects expiries which are not in the future
+func expiry(order *Order, now MillisecondTimestamp) error {
+	if !order.expirable() {
+		return nil
+	}
+	if order.TimeInForce == DayTimeInForce {
+		end := (now/tradingDay + 1) * tradingDay
+		if order.ExpireAt == 0 {
+			order.ExpireAt = end
+		}
+		if order.ExpireAt > end {
+			return ErrBadExpiry
+		}
+	}
+	if order.ExpireAt <= now {
+		return ErrBadExpiry
+	}
+	return nil
+}
+
+func (ob *OrderBook) find(id OrderID) (*OrderContainer, *list.Element, bool) {
+	if el, ok := ob.buy.find(id); ok {
+		return ob.buy, el, true
+	}
+	if el, ok := ob.sell.find(id); ok {
+		return ob.sell, el, true
+	}
+	return nil, nil, false
+}
+
+func (ob *OrderBook) CancelOrder(id OrderID) (Transaction, error) {
+	oc, el, ok := ob.find(id)
+	if !ok {
+		oc, el, ok = ob.findStop(id)
+	}
+	if !ok {
+		return Transaction{}, ErrOrderNotFound
+	}
+
+	order := el.Value.(*Order)
+	return ob.newTransaction([]*Order{order}, nil, func() {
+		oc.removeOrder(el)
+	}), nil
+}
+
+// reducing amount at the same price keeps queue position, anything else re-queues the order
+func (ob *OrderBook) AmendOrder(id OrderID, price, amount decimal.Decimal) (Transaction, error) {
+	if price.Sign() <= 0 {
 		return Transaction{}, ErrBadPrice
 	}
-	if order.Amount.Sign() <= 0 {
+	if amount.Sign() <= 0 {
 		return Transaction{}, ErrBadAmount
 	}
 
-	if order.Type == MarketOrderType {
-		return ob.matchMarketOrder(order)
+	oc, el, ok := ob.find(id)
+	if !ok {
+		return Transaction{}, ErrOrderNotFound
 	}
 
-	return ob.matchLimitOrder(order)
+	order := el.Value.(*Order)
+	replacement := *order
+	replacement.Price = price
+	replacement.Amount = amount
+	if err := ob.instrument.check(&replacement); err != nil {
+		return Transaction{}, err
+	}
+
+	if price.Equal(order.Price) && amount.LessThanOrEqual(order.Amount) {
+		return ob.newTransaction(nil, nil, func() {
+			oc.reduce(el, amount)
+		}), nil
+	}
+
+	replacement.Timestamp = ob.clock.Now()
+
+	tr, err := ob.matchLimitOrder(&replacement)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	finalizer := tr.finalize
+	tr.finalize = func() {
+		oc.removeOrder(el)
+		finalizer()
+	}
+	return tr, nil
 }
 
-// market orders should be processed immediately
+// market orders should be processed immediately, whatever can not be filled is cancelled
 func (ob *OrderBook) matchMarketOrder(order *Order) (Transaction, error) {
-	if order.Dir == BuyOrderDirection {
-		if order.Amount.LessThan(ob.sell.Volume()) {
-			return newTransaction(nil, func() {}), nil
+	limit, err := ob.protectionPrice(order)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	m := ob.match(order, limit, false)
+	if order.TimeInForce == FOKTimeInForce && (m.left.Sign() > 0 || m.takerCancelled) {
+		return Transaction{}, ErrOrderKilled
+	}
+
+	m.cancel(order)
+	return ob.matchTransaction(m), nil
+}
+
+func (ob *OrderBook) matchLimitOrder(order *Order) (Transaction, error) {
+	if order.PostOnly != NoPostOnly {
+		if best, ok := ob.crosses(order); ok {
+			return ob.repriceOrder(order, best)
+		}
+	}
+
+	m := ob.match(order, &order.Price, false)
+	if m.left.IsZero() && !m.takerCancelled {
+		m.orders = append(m.orders, order)
+		return ob.matchTransaction(m), nil
+	}
+
+	switch {
+	case order.TimeInForce == FOKTimeInForce:
+		return Transaction{}, ErrOrderKilled
+	case order.TimeInForce == IOCTimeInForce, m.takerCancelled:
+		m.cancel(order)
+		return ob.matchTransaction(m), nil
+	}
+
+	own := ob.side(order.Dir)
+	finalizer := m.finalize
+	m.finalize = func() {
+		finalizer()
+		order.Amount = m.left
+		own.Add(order)
+	}
+	return ob.matchTransaction(m), nil
+}
+
+// protectionPrice returns the worst price a market order may be filled at, nil means no limit
+func (ob *OrderBook) protectionPrice(order *Order) (*decimal.Decimal, error) {
+	if order.Protection.Sign() < 0 {
+		return nil, ErrBadPrice
+	}
+	if order.MaxSlippage.Sign() < 0 {
+		return nil, ErrBadSlippage
+	}
+
+	var limit *decimal.Decimal
+	if order.Protection.Sign() > 0 {
+		limit = &order.Protection
+	}
+	if order.MaxSlippage.IsZero() {
+		return limit, nil
+	}
+
+	best, ok := ob.bestOpposite(order.Dir)
+	if !ok {
+		return limit, nil
+	}
+
+	var offset decimal.Decimal
+	switch order.SlippageType {
+	case TickSlippageType:
+		if ob.tickSize.Sign() <= 0 {
+			return nil, ErrBadSlippage
 		}
+		offset = order.MaxSlippage.Mul(ob.tickSize)
+	case PercentSlippageType:
+		offset = best.Mul(order.MaxSlippage).Div(decimal.NewFromInt(100))
+	default:
+		return nil, ErrBadSlippage
+	}
 
-		doneOrders, amountLeft, finalizer := ob.sell.matchMinPrice(order, nil)
-		if amountLeft.GreaterThan(decimal.Zero) {
-			panic("market volume assert")
+	if order.Dir == BuyOrderDirection {
+		price := best.Add(offset)
+		if limit == nil || price.LessThan(*limit) {
+			limit = &price
 		}
-		doneOrders = append(doneOrders, order)
-		return newTransaction(doneOrders, finalizer), nil
+		return limit, nil
 	}
 
-	if order.Amount.LessThan(ob.buy.Volume()) {
-		return newTransaction(nil, func() {}), nil
+	price := best.Sub(offset)
+	if limit == nil || price.GreaterThan(*limit) {
+		limit = &price
 	}
+	return limit, nil
+}
 
-	doneOrders, amountLeft, finalizer := ob.buy.matchMaxPrice(order, nil)
-	if amountLeft.GreaterThan(decimal.Zero) {
-		panic("market volume assert")
+// bestOpposite returns the best price an order of given direction could be matched at
+func (ob *OrderBook) bestOpposite(dir OrderDirection) (decimal.Decimal, bool) {
+	best, ok := ob.BestBid()
+	if dir == BuyOrderDirection {
+		best, ok = ob.BestAsk()
 	}
-	doneOrders = append(doneOrders, order)
-	return newTransaction(doneOrders, finalizer), nil
+	return best.Price, ok
 }
 
-func (ob *OrderBook) matchLimitOrder(order *Order) (Transaction, error) {
+// crosses checks order price against the opposite best price
+func (ob *OrderBook) crosses(order *Order) (decimal.Decimal, bool) {
+	best, ok := ob.bestOpposite(order.Dir)
+	if !ok {
+		return decimal.Zero, false
+	}
 	if order.Dir == BuyOrderDirection {
-		doneOrders, amountLeft, finalizer := ob.sell.matchMinPrice(order, &order.Price)
-		if amountLeft.GreaterThan(decimal.Zero) {
-			return newTransaction(doneOrders, func() {
-				finalizer()
-				order.Amount = amountLeft
-				ob.buy.Add(order)
-			}), nil
-		}
-		doneOrders = append(doneOrders, order)
-		return newTransaction(doneOrders, finalizer), nil
-	}
-
-	doneOrders, amountLeft, finalizer := ob.buy.matchMaxPrice(order, &order.Price)
-	if amountLeft.GreaterThan(decimal.Zero) {
-		return newTransaction(doneOrders, func() {
-			finalizer()
-			order.Amount = amountLeft
-			ob.sell.Add(order)
-		}), nil
+		return best, order.Price.GreaterThanOrEqual(best)
+	}
+	return best, order.Price.LessThanOrEqual(best)
+}
+
+// post only order which would take liquidity is rejected or rests one tick behind the opposite best price
+func (ob *OrderBook) repriceOrder(order *Order, best decimal.Decimal) (Transaction, error) {
+	price, err := ob.repricedPrice(order, best)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	own := ob.side(order.Dir)
+	return ob.newTransaction(nil, nil, func() {
+		order.Price = price
+		own.Add(order)
+	}), nil
+}
+
+// repricedPrice returns the price a crossing post only order rests at, it is shared with Simulate
+func (ob *OrderBook) repricedPrice(order *Order, best decimal.Decimal) (decimal.Decimal, error) {
+	if order.PostOnly != RepricePostOnly || ob.tickSize.Sign() <= 0 {
+		return decimal.Zero, ErrPostOnly
+	}
+	if order.TimeInForce == IOCTimeInForce || order.TimeInForce == FOKTimeInForce {
+		return decimal.Zero, ErrPostOnly
+	}
+
+	price := best.Add(ob.tickSize)
+	if order.Dir == BuyOrderDirection {
+		price = best.Sub(ob.tickSize)
+	}
+	if price.Sign() <= 0 {
+		return decimal.Zero, ErrPostOnly
+	}
+	return price, nil
+}
+
+// match runs order against the opposite side of the book
+func (ob *OrderBook) match(order *Order, stopPrice *decimal.Decimal, dry bool) matching {
+	if order.Dir == BuyOrderDirection {
+		return ob.sell.matchMinPrice(order, stopPrice, dry)
+	}
+	return ob.buy.matchMaxPrice(order, stopPrice, dry)
+}
+
+func (ob *OrderBook) side(dir OrderDirection) *OrderContainer {
+	if dir == BuyOrderDirection {
+		return ob.buy
 	}
-	doneOrders = append(doneOrders, order)
-	return newTransaction(doneOrders, finalizer), nil
+	return ob.sell
+}
+
+func (ob *OrderBook) opposite(dir OrderDirection) *OrderContainer {
+	if dir == BuyOrderDirection {
+		return ob.sell
+	}
+	return ob.buy
+}
+
+// ExpireOrders removes GTD and DAY orders which expire at or before now
+func (ob *OrderBook) ExpireOrders(now MillisecondTimestamp) (Transaction, error) {
+	containers := []*OrderContainer{ob.buy, ob.sell, ob.buyStops, ob.sellStops}
+	expired := make([][]*list.Element, len(containers))
+
+	orders := make([]*Order, 0)
+	for i, oc := range containers {
+		expired[i] = oc.expired(now)
+		for _, el := range expired[i] {
+			orders = append(orders, el.Value.(*Order))
+		}
+	}
+
+	return ob.newTransaction(orders, nil, func() {
+		for i, oc := range containers {
+			for _, el := range expired[i] {
+				oc.removeOrder(el)
+			}
+		}
+	}), nil
+}
+
+func (ob *OrderBook) settle(trades []Trade) {
+	now := ob.clock.Now()
+	for i := range trades {
+		ob.lastTradeID++
+		trades[i].ID = ob.lastTradeID
+		trades[i].Timestamp = now
+		ob.lastPrice = trades[i].Price
+	}
+}
+
+type TradeID uint64
+
+type Trade struct {
+	Amount    decimal.Decimal      `json:"amount"`
+	Price     decimal.Decimal      `json:"price"`
+	ID        TradeID              `json:"id"`
+	Maker     OrderID              `json:"maker"`
+	Taker     OrderID              `json:"taker"`
+	Aggressor OrderDirection       `json:"aggressor"`
+	Timestamp MillisecondTimestamp `json:"timestamp"`
+}
+
+func newTrade(maker, taker *Order, price, amount decimal.Decimal) Trade {
+	return Trade{
+		Amount:    amount,
+		Price:     price,
+		Maker:     maker.ID,
+		Taker:     taker.ID,
+		Aggressor: taker.Dir,
+	}
+}
+
+// SelfTrade is a match between orders of the same owner which has been prevented
+type SelfTrade struct {
+	Amount decimal.Decimal `json:"amount"`
+	Maker  OrderID         `json:"maker"`
+	Taker  OrderID         `json:"taker"`
+	Mode   STPMode         `json:"mode"`
+}
+
+func newSelfTrade(maker, taker *Order, amount decimal.Decimal) SelfTrade {
+	return SelfTrade{
+		Amount: amount,
+		Maker:  maker.ID,
+		Taker:  taker.ID,
+		Mode:   taker.STP,
+	}
+}
+
+// Cancellation is an unfilled remainder of an order which has been dropped instead of resting in the book,
+// Amount is in quote currency for quote orders
+type Cancellation struct {
+	Amount decimal.Decimal `json:"amount"`
+	ID     OrderID         `json:"id"`
+}
+
+// cancellation drops the whole order
+func cancellation(order *Order) Cancellation {
+	if order.quote() {
+		return Cancellation{Amount: order.Notional, ID: order.ID}
+	}
+	return Cancellation{Amount: order.Amount, ID: order.ID}
 }
 
 type Transaction struct {
-	orders   []*Order
-	finalize finalizerFn
+	book      *OrderBook
+	orders    []*Order
+	trades    []Trade
+	prevented []SelfTrade
+	cancelled []Cancellation
+	finalize  finalizerFn
+	prepare   func() error // runs before finalize, a failed one leaves the book unchanged
+	version   uint64
 }
 
-func newTransaction(orders []*Order, finalize finalizerFn) Transaction {
+func (ob *OrderBook) newTransaction(orders []*Order, trades []Trade, finalize finalizerFn) Transaction {
 	return Transaction{
+		book:     ob,
 		orders:   orders,
+		trades:   trades,
 		finalize: finalize,
+		version:  ob.version,
 	}
 }
 
-func (tr *Transaction) Commit() ([]*Order, error) {
+func (ob *OrderBook) matchTransaction(m matching) Transaction {
+	tr := ob.newTransaction(m.orders, m.trades, m.finalize)
+	tr.prevented = m.prevented
+	tr.cancelled = m.cancelled
+	return tr
+}
+
+// Orders returns orders which have been fully processed by the transaction
+func (tr *Transaction) Orders() []*Order {
+	return tr.orders
+}
+
+// Trades returns trades of the transaction, they get their IDs and timestamps on commit
+func (tr *Transaction) Trades() []Trade {
+	return tr.trades
+}
+
+// Prevented returns self-trades which have been prevented by the transaction
+func (tr *Transaction) Prevented() []SelfTrade {
+	return tr.prevented
+}
+
+// Cancelled returns unfilled remainders of market, IOC and self-trade cancelled orders
+func (tr *Transaction) Cancelled() []Cancellation {
+	return tr.cancelled
+}
+
+// Commit applies the transaction, it fails with ErrStaleTransaction if another one has been committed since it was created
+func (tr *Transaction) Commit() ([]Trade, error) {
 	if tr.finalize != nil {
+		if err := tr.check(); err != nil {
+			return nil, err
+		}
+		if tr.prepare != nil {
+			if err := tr.prepare(); err != nil {
+				return nil, err
+			}
+			tr.prepare = nil
+		}
+		tr.book.version++
+
 		tr.finalize()
 		tr.finalize = nil
+		tr.book.settle(tr.trades)
+
+		// stops triggered by this transaction fills belong to it as well
+		tr.book.activateStops(tr)
+		tr.book.publish(tr.trades)
+	}
+	return tr.trades, nil
+}
+
+// check reports a transaction which can not be committed anymore
+func (tr *Transaction) check() error {
+	if tr.finalize != nil && tr.version != tr.book.version {
+		return ErrStaleTransaction
 	}
-	return tr.orders, nil
+	return nil
+}
+
+// invalidate makes transactions evaluated so far stale, batches included
+func (ob *OrderBook) invalidate() {
+	ob.version++
 }
 
 func (tr *Transaction) Rollback() error {
 	tr.orders = nil
+	tr.trades = nil
+	tr.prevented = nil
+	tr.cancelled = nil
 	tr.finalize = nil
+	tr.prepare = nil
 	return nil
 }
 
 var (
 	ErrBadPrice  = errors.New("bad price value")
 	ErrBadAmount = errors.New("bad amount value")
+
+	ErrOrderNotFound  = errors.New("order not found")
+	ErrDuplicateOrder = errors.New("duplicate order id")
+
+	ErrBadTimeInForce = errors.New("bad time in force value")
+	ErrBadExpiry      = errors.New("bad expiry value")
+	ErrOrderKilled    = errors.New("fill or kill order can not be filled")
+
+	ErrPostOnly = errors.New("post only order would take liquidity")
+
+	ErrBadSlippage = errors.New("bad slippage value")
+	ErrBadNotional = errors.New("bad notional value")
+
+	ErrBadStopPrice = errors.New("bad stop price value")
+	ErrStopTrigger  = errors.New("stop price already reached")
+
+	ErrStaleTransaction = errors.New("transaction is stale")
 )
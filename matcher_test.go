@@ -8,14 +8,29 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// newTestOrderBook uses a stopped clock, so resting orders keep comparing equal to submitted ones
+func newTestOrderBook(opts ...OrderBookOption) *OrderBook {
+	return NewOrderBook(append([]OrderBookOption{WithClock(NewManualClock(0))}, opts...)...)
+}
+
 func submitOrder(t *testing.T, ob *OrderBook, o Order) []*Order {
 	tr, err := ob.SubmitOrder(&o)
 	require.NoError(t, err)
 
-	ol, err := tr.Commit()
+	_, err = tr.Commit()
+	require.NoError(t, err)
+
+	return tr.Orders()
+}
+
+func submitTrades(t *testing.T, ob *OrderBook, o Order) []Trade {
+	tr, err := ob.SubmitOrder(&o)
+	require.NoError(t, err)
+
+	trades, err := tr.Commit()
 	require.NoError(t, err)
 
-	return ol
+	return trades
 }
 
 func getQueues(oc *OrderContainer) []*OrderQueue {
@@ -28,7 +43,7 @@ func getQueues(oc *OrderContainer) []*OrderQueue {
 
 func TestLimitOrders(t *testing.T) {
 	t.Run("init buy", func(t *testing.T) {
-		ob := NewOrderBook()
+		ob := newTestOrderBook()
 		expected := []Order{
 			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection},
 			{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: BuyOrderDirection},
@@ -82,7 +97,7 @@ func TestLimitOrders(t *testing.T) {
 	})
 
 	t.Run("first buy, part of queue", func(t *testing.T) {
-		ob := NewOrderBook()
+		ob := newTestOrderBook()
 		sell := []Order{
 			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
 			{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: SellOrderDirection},
@@ -127,7 +142,7 @@ func TestLimitOrders(t *testing.T) {
 	})
 
 	t.Run("first buy, full queue", func(t *testing.T) {
-		ob := NewOrderBook()
+		ob := newTestOrderBook()
 		sell := []Order{
 			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
 			{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: SellOrderDirection},
@@ -165,7 +180,7 @@ func TestLimitOrders(t *testing.T) {
 	})
 
 	t.Run("first buy, all queues", func(t *testing.T) {
-		ob := NewOrderBook()
+		ob := newTestOrderBook()
 		sell := []Order{
 			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
 			{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: SellOrderDirection},
@@ -197,7 +212,7 @@ func TestLimitOrders(t *testing.T) {
 	})
 
 	t.Run("init sell", func(t *testing.T) {
-		ob := NewOrderBook()
+		ob := newTestOrderBook()
 		expected := []Order{
 			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
 			{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: SellOrderDirection},
@@ -251,7 +266,7 @@ func TestLimitOrders(t *testing.T) {
 	})
 
 	t.Run("first sell, part of queue", func(t *testing.T) {
-		ob := NewOrderBook()
+		ob := newTestOrderBook()
 		buy := []Order{
 			{ID: 1, Price: decimal.NewFromFloat(20.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection},
 			{ID: 2, Price: decimal.NewFromFloat(20.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: BuyOrderDirection},
@@ -296,7 +311,7 @@ func TestLimitOrders(t *testing.T) {
 	})
 
 	t.Run("first sell, full queue", func(t *testing.T) {
-		ob := NewOrderBook()
+		ob := newTestOrderBook()
 		buy := []Order{
 			{ID: 1, Price: decimal.NewFromFloat(20.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection},
 			{ID: 2, Price: decimal.NewFromFloat(20.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: BuyOrderDirection},
@@ -335,7 +350,7 @@ func TestLimitOrders(t *testing.T) {
 	})
 
 	t.Run("first buy, all queues", func(t *testing.T) {
-		ob := NewOrderBook()
+		ob := newTestOrderBook()
 		buy := []Order{
 			{ID: 1, Price: decimal.NewFromFloat(20.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection},
 			{ID: 2, Price: decimal.NewFromFloat(20.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: BuyOrderDirection},
@@ -369,7 +384,7 @@ func TestLimitOrders(t *testing.T) {
 
 func TestMarketOrders(t *testing.T) {
 	t.Run("first buy, part of queue", func(t *testing.T) {
-		ob := NewOrderBook()
+		ob := newTestOrderBook()
 		sell := []Order{
 			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
 			{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: SellOrderDirection},
@@ -414,7 +429,7 @@ func TestMarketOrders(t *testing.T) {
 	})
 
 	t.Run("first buy, full queue", func(t *testing.T) {
-		ob := NewOrderBook()
+		ob := newTestOrderBook()
 		sell := []Order{
 			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
 			{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: SellOrderDirection},
@@ -452,7 +467,7 @@ func TestMarketOrders(t *testing.T) {
 	})
 
 	t.Run("first buy, all queues", func(t *testing.T) {
-		ob := NewOrderBook()
+		ob := newTestOrderBook()
 		sell := []Order{
 			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
 			{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: SellOrderDirection},
@@ -484,7 +499,7 @@ func TestMarketOrders(t *testing.T) {
 	})
 
 	t.Run("first sell, part of queue", func(t *testing.T) {
-		ob := NewOrderBook()
+		ob := newTestOrderBook()
 		buy := []Order{
 			{ID: 1, Price: decimal.NewFromFloat(20.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection},
 			{ID: 2, Price: decimal.NewFromFloat(20.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: BuyOrderDirection},
@@ -529,7 +544,7 @@ func TestMarketOrders(t *testing.T) {
 	})
 
 	t.Run("first sell, full queue", func(t *testing.T) {
-		ob := NewOrderBook()
+		ob := newTestOrderBook()
 		buy := []Order{
 			{ID: 1, Price: decimal.NewFromFloat(20.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection},
 			{ID: 2, Price: decimal.NewFromFloat(20.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: BuyOrderDirection},
@@ -568,7 +583,7 @@ func TestMarketOrders(t *testing.T) {
 	})
 
 	t.Run("first buy, all queues", func(t *testing.T) {
-		ob := NewOrderBook()
+		ob := newTestOrderBook()
 		buy := []Order{
 			{ID: 1, Price: decimal.NewFromFloat(20.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection},
 			{ID: 2, Price: decimal.NewFromFloat(20.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: BuyOrderDirection},
@@ -599,3 +614,989 @@ func TestMarketOrders(t *testing.T) {
 		require.Equal(t, 0, len(queues))
 	})
 }
+
+func TestCancelOrder(t *testing.T) {
+	t.Run("middle of queue", func(t *testing.T) {
+		ob := NewOrderBook()
+		sell := []Order{
+			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 3, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(200.0), Type: LimitOrderType, Dir: SellOrderDirection},
+		}
+		for _, v := range sell {
+			submitOrder(t, ob, v)
+		}
+
+		tr, err := ob.CancelOrder(2)
+		require.NoError(t, err)
+		_, err = tr.Commit()
+		require.NoError(t, err)
+		o := tr.Orders()
+		require.Equal(t, 1, len(o))
+		require.Equal(t, OrderID(2), o[0].ID)
+
+		queues := getQueues(ob.sell)
+		require.Equal(t, 1, len(queues))
+
+		e := queues[0].orders.Front()
+		require.Equal(t, OrderID(1), e.Value.(*Order).ID)
+		e = e.Next()
+		require.Equal(t, OrderID(3), e.Value.(*Order).ID)
+		e = e.Next()
+		require.Nil(t, e)
+
+		require.True(t, decimal.NewFromFloat(300.0).Equal(queues[0].Volume()))
+		require.True(t, decimal.NewFromFloat(300.0).Equal(ob.sell.Volume()))
+	})
+
+	t.Run("last order of level", func(t *testing.T) {
+		ob := NewOrderBook()
+		buy := []Order{
+			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+			{ID: 2, Price: decimal.NewFromFloat(15.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+		}
+		for _, v := range buy {
+			submitOrder(t, ob, v)
+		}
+
+		tr, err := ob.CancelOrder(2)
+		require.NoError(t, err)
+		_, err = tr.Commit()
+		require.NoError(t, err)
+
+		queues := getQueues(ob.buy)
+		require.Equal(t, 1, len(queues))
+		require.True(t, decimal.NewFromFloat(10.0).Equal(queues[0].Price()))
+		require.Equal(t, 1, len(ob.buy.priceHash))
+		require.True(t, decimal.NewFromFloat(100.0).Equal(ob.buy.Volume()))
+
+		_, err = ob.CancelOrder(2)
+		require.ErrorIs(t, err, ErrOrderNotFound)
+	})
+
+	t.Run("partially filled order", func(t *testing.T) {
+		ob := NewOrderBook()
+		submitOrder(t, ob, Order{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection})
+		submitOrder(t, ob, Order{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(40.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.True(t, decimal.NewFromFloat(60.0).Equal(ob.sell.Volume()))
+
+		tr, err := ob.CancelOrder(1)
+		require.NoError(t, err)
+		_, err = tr.Commit()
+		require.NoError(t, err)
+
+		require.Equal(t, 0, len(getQueues(ob.sell)))
+		require.True(t, ob.sell.Volume().IsZero())
+	})
+
+	t.Run("filled order is not cancellable", func(t *testing.T) {
+		ob := NewOrderBook()
+		submitOrder(t, ob, Order{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection})
+		submitOrder(t, ob, Order{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+
+		_, err := ob.CancelOrder(1)
+		require.ErrorIs(t, err, ErrOrderNotFound)
+		_, err = ob.CancelOrder(2)
+		require.ErrorIs(t, err, ErrOrderNotFound)
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		ob := NewOrderBook()
+		submitOrder(t, ob, Order{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection})
+
+		tr, err := ob.CancelOrder(1)
+		require.NoError(t, err)
+		require.NoError(t, tr.Rollback())
+
+		require.Equal(t, 1, len(getQueues(ob.sell)))
+		require.True(t, decimal.NewFromFloat(100.0).Equal(ob.sell.Volume()))
+	})
+
+	t.Run("duplicate id", func(t *testing.T) {
+		ob := NewOrderBook()
+		submitOrder(t, ob, Order{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection})
+
+		_, err := ob.SubmitOrder(&Order{ID: 1, Price: decimal.NewFromFloat(5.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.ErrorIs(t, err, ErrDuplicateOrder)
+	})
+}
+
+func TestAmendOrder(t *testing.T) {
+	init := func(t *testing.T) *OrderBook {
+		ob := NewOrderBook()
+		sell := []Order{
+			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 3, Price: decimal.NewFromFloat(15.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 4, Price: decimal.NewFromFloat(5.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+		}
+		for _, v := range sell {
+			submitOrder(t, ob, v)
+		}
+		return ob
+	}
+
+	amend := func(t *testing.T, ob *OrderBook, id OrderID, price, amount float64) []*Order {
+		tr, err := ob.AmendOrder(id, decimal.NewFromFloat(price), decimal.NewFromFloat(amount))
+		require.NoError(t, err)
+		_, err = tr.Commit()
+		require.NoError(t, err)
+		return tr.Orders()
+	}
+
+	ids := func(q *OrderQueue) []OrderID {
+		a := make([]OrderID, 0)
+		for e := q.orders.Front(); e != nil; e = e.Next() {
+			a = append(a, e.Value.(*Order).ID)
+		}
+		return a
+	}
+
+	t.Run("reduce keeps priority", func(t *testing.T) {
+		ob := init(t)
+		amend(t, ob, 1, 10.0, 40.0)
+
+		queue := ob.sell.priceHash[decimal.NewFromFloat(10.0).String()]
+		require.Equal(t, []OrderID{1, 2}, ids(queue))
+		require.True(t, decimal.NewFromFloat(40.0).Equal(queue.orders.Front().Value.(*Order).Amount))
+		require.True(t, decimal.NewFromFloat(190.0).Equal(queue.Volume()))
+		require.True(t, decimal.NewFromFloat(290.0).Equal(ob.sell.Volume()))
+	})
+
+	t.Run("increase loses priority", func(t *testing.T) {
+		ob := init(t)
+		amend(t, ob, 1, 10.0, 200.0)
+
+		queue := ob.sell.priceHash[decimal.NewFromFloat(10.0).String()]
+		require.Equal(t, []OrderID{2, 1}, ids(queue))
+		require.True(t, decimal.NewFromFloat(350.0).Equal(queue.Volume()))
+		require.True(t, decimal.NewFromFloat(450.0).Equal(ob.sell.Volume()))
+	})
+
+	t.Run("price change moves level", func(t *testing.T) {
+		ob := init(t)
+		amend(t, ob, 1, 15.0, 100.0)
+
+		queues := getQueues(ob.sell)
+		require.Equal(t, 2, len(queues))
+		require.Equal(t, []OrderID{2}, ids(queues[0]))
+		require.Equal(t, []OrderID{3, 1}, ids(queues[1]))
+		require.True(t, decimal.NewFromFloat(350.0).Equal(ob.sell.Volume()))
+	})
+
+	t.Run("price change crosses", func(t *testing.T) {
+		ob := init(t)
+		o := amend(t, ob, 4, 10.0, 120.0)
+		require.Equal(t, 2, len(o))
+		require.Equal(t, OrderID(1), o[0].ID)
+		require.Equal(t, OrderID(4), o[1].ID)
+
+		require.Equal(t, 0, len(getQueues(ob.buy)))
+		require.True(t, ob.buy.Volume().IsZero())
+
+		queue := ob.sell.priceHash[decimal.NewFromFloat(10.0).String()]
+		require.Equal(t, []OrderID{2}, ids(queue))
+		require.True(t, decimal.NewFromFloat(130.0).Equal(queue.Volume()))
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		ob := init(t)
+		tr, err := ob.AmendOrder(1, decimal.NewFromFloat(15.0), decimal.NewFromFloat(100.0))
+		require.NoError(t, err)
+		require.NoError(t, tr.Rollback())
+
+		queue := ob.sell.priceHash[decimal.NewFromFloat(10.0).String()]
+		require.Equal(t, []OrderID{1, 2}, ids(queue))
+		require.True(t, decimal.NewFromFloat(350.0).Equal(ob.sell.Volume()))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		ob := init(t)
+		_, err := ob.AmendOrder(42, decimal.NewFromFloat(10.0), decimal.NewFromFloat(100.0))
+		require.ErrorIs(t, err, ErrOrderNotFound)
+		_, err = ob.AmendOrder(1, decimal.Zero, decimal.NewFromFloat(100.0))
+		require.ErrorIs(t, err, ErrBadPrice)
+		_, err = ob.AmendOrder(1, decimal.NewFromFloat(10.0), decimal.Zero)
+		require.ErrorIs(t, err, ErrBadAmount)
+	})
+}
+
+func TestTrades(t *testing.T) {
+	t.Run("partial maker fill", func(t *testing.T) {
+		ob := NewOrderBook()
+		submitOrder(t, ob, Order{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection})
+
+		trades := submitTrades(t, ob, Order{ID: 2, Price: decimal.NewFromFloat(12.0), Amount: decimal.NewFromFloat(40.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.Equal(t, 1, len(trades))
+		require.Equal(t, TradeID(1), trades[0].ID)
+		require.Equal(t, OrderID(1), trades[0].Maker)
+		require.Equal(t, OrderID(2), trades[0].Taker)
+		require.Equal(t, BuyOrderDirection, trades[0].Aggressor)
+		require.True(t, decimal.NewFromFloat(10.0).Equal(trades[0].Price))
+		require.True(t, decimal.NewFromFloat(40.0).Equal(trades[0].Amount))
+	})
+
+	t.Run("sweep several levels", func(t *testing.T) {
+		ob := NewOrderBook()
+		buy := []Order{
+			{ID: 1, Price: decimal.NewFromFloat(20.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+			{ID: 2, Price: decimal.NewFromFloat(20.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+			{ID: 3, Price: decimal.NewFromFloat(25.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+		}
+		for _, v := range buy {
+			submitOrder(t, ob, v)
+		}
+
+		trades := submitTrades(t, ob, Order{ID: 4, Price: decimal.NewFromFloat(15.0), Amount: decimal.NewFromFloat(300.0), Type: LimitOrderType, Dir: SellOrderDirection})
+		require.Equal(t, 3, len(trades))
+
+		expected := []struct {
+			maker  OrderID
+			price  float64
+			amount float64
+		}{
+			{3, 25.0, 100.0},
+			{1, 20.0, 100.0},
+			{2, 20.0, 100.0},
+		}
+		for i, e := range expected {
+			require.Equal(t, TradeID(i+1), trades[i].ID)
+			require.Equal(t, e.maker, trades[i].Maker)
+			require.Equal(t, OrderID(4), trades[i].Taker)
+			require.Equal(t, SellOrderDirection, trades[i].Aggressor)
+			require.True(t, decimal.NewFromFloat(e.price).Equal(trades[i].Price))
+			require.True(t, decimal.NewFromFloat(e.amount).Equal(trades[i].Amount))
+		}
+
+		trades = submitTrades(t, ob, Order{ID: 5, Price: decimal.NewFromFloat(15.0), Amount: decimal.NewFromFloat(50.0), Type: MarketOrderType, Dir: SellOrderDirection})
+		require.Equal(t, 1, len(trades))
+		require.Equal(t, TradeID(4), trades[0].ID)
+		require.Equal(t, OrderID(2), trades[0].Maker)
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		ob := NewOrderBook()
+		submitOrder(t, ob, Order{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection})
+
+		tr, err := ob.SubmitOrder(&Order{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(40.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.NoError(t, err)
+		require.NoError(t, tr.Rollback())
+
+		trades, err := tr.Commit()
+		require.NoError(t, err)
+		require.Equal(t, 0, len(trades))
+
+		trades = submitTrades(t, ob, Order{ID: 3, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(40.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.Equal(t, TradeID(1), trades[0].ID)
+	})
+}
+
+func TestTimeInForce(t *testing.T) {
+	init := func(t *testing.T) *OrderBook {
+		ob := NewOrderBook(WithClock(NewManualClock(500)))
+		sell := []Order{
+			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 2, Price: decimal.NewFromFloat(15.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
+		}
+		for _, v := range sell {
+			submitOrder(t, ob, v)
+		}
+		return ob
+	}
+
+	t.Run("ioc remainder is cancelled", func(t *testing.T) {
+		ob := init(t)
+		buy := Order{ID: 3, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: BuyOrderDirection, TimeInForce: IOCTimeInForce}
+
+		tr, err := ob.SubmitOrder(&buy)
+		require.NoError(t, err)
+		trades, err := tr.Commit()
+		require.NoError(t, err)
+
+		require.Equal(t, 1, len(trades))
+		require.True(t, decimal.NewFromFloat(100.0).Equal(trades[0].Amount))
+		require.Equal(t, 2, len(tr.Orders()))
+		require.Equal(t, OrderID(1), tr.Orders()[0].ID)
+		require.Equal(t, &buy, tr.Orders()[1])
+		require.Equal(t, 0, len(getQueues(ob.buy)))
+		require.True(t, ob.buy.Volume().IsZero())
+		require.True(t, decimal.NewFromFloat(100.0).Equal(ob.sell.Volume()))
+	})
+
+	t.Run("fok rejected", func(t *testing.T) {
+		ob := init(t)
+		_, err := ob.SubmitOrder(&Order{ID: 3, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: BuyOrderDirection, TimeInForce: FOKTimeInForce})
+		require.ErrorIs(t, err, ErrOrderKilled)
+
+		require.Equal(t, 2, len(getQueues(ob.sell)))
+		require.True(t, decimal.NewFromFloat(200.0).Equal(ob.sell.Volume()))
+	})
+
+	t.Run("fok filled", func(t *testing.T) {
+		ob := init(t)
+		trades := submitTrades(t, ob, Order{ID: 3, Price: decimal.NewFromFloat(15.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: BuyOrderDirection, TimeInForce: FOKTimeInForce})
+		require.Equal(t, 2, len(trades))
+		require.True(t, decimal.NewFromFloat(50.0).Equal(ob.sell.Volume()))
+	})
+
+	t.Run("bad values", func(t *testing.T) {
+		ob := init(t)
+		_, err := ob.SubmitOrder(&Order{ID: 3, Price: decimal.NewFromFloat(5.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection, TimeInForce: GTDTimeInForce})
+		require.ErrorIs(t, err, ErrBadExpiry)
+		_, err = ob.SubmitOrder(&Order{ID: 3, Price: decimal.NewFromFloat(5.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection, TimeInForce: GTDTimeInForce, ExpireAt: 500})
+		require.ErrorIs(t, err, ErrBadExpiry)
+		_, err = ob.SubmitOrder(&Order{ID: 3, Price: decimal.NewFromFloat(5.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection, TimeInForce: DayTimeInForce, ExpireAt: 400})
+		require.ErrorIs(t, err, ErrBadExpiry)
+		_, err = ob.SubmitOrder(&Order{ID: 3, Price: decimal.NewFromFloat(5.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection, TimeInForce: DayTimeInForce, ExpireAt: tradingDay + 1})
+		require.ErrorIs(t, err, ErrBadExpiry)
+		_, err = ob.SubmitOrder(&Order{ID: 3, Price: decimal.NewFromFloat(5.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection, TimeInForce: 42})
+		require.ErrorIs(t, err, ErrBadTimeInForce)
+	})
+
+	t.Run("expiry sweep", func(t *testing.T) {
+		ob := init(t)
+		buy := []Order{
+			{ID: 3, Price: decimal.NewFromFloat(5.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection, TimeInForce: GTDTimeInForce, ExpireAt: 2000},
+			{ID: 4, Price: decimal.NewFromFloat(5.0), Amount: decimal.NewFromFloat(20.0), Type: LimitOrderType, Dir: BuyOrderDirection, TimeInForce: DayTimeInForce, ExpireAt: 1000},
+			{ID: 5, Price: decimal.NewFromFloat(6.0), Amount: decimal.NewFromFloat(30.0), Type: LimitOrderType, Dir: BuyOrderDirection, TimeInForce: GTDTimeInForce, ExpireAt: 1500},
+			{ID: 6, Price: decimal.NewFromFloat(20.0), Amount: decimal.NewFromFloat(40.0), Type: LimitOrderType, Dir: SellOrderDirection, TimeInForce: GTDTimeInForce, ExpireAt: 1000},
+		}
+		for _, v := range buy {
+			submitOrder(t, ob, v)
+		}
+
+		tr, err := ob.ExpireOrders(1500)
+		require.NoError(t, err)
+		_, err = tr.Commit()
+		require.NoError(t, err)
+
+		ids := make([]OrderID, 0)
+		for _, o := range tr.Orders() {
+			ids = append(ids, o.ID)
+		}
+		require.Equal(t, []OrderID{4, 5, 6}, ids)
+
+		queues := getQueues(ob.buy)
+		require.Equal(t, 1, len(queues))
+		require.Equal(t, OrderID(3), queues[0].orders.Front().Value.(*Order).ID)
+		require.True(t, decimal.NewFromFloat(10.0).Equal(ob.buy.Volume()))
+		require.True(t, decimal.NewFromFloat(200.0).Equal(ob.sell.Volume()))
+
+		tr, err = ob.ExpireOrders(1500)
+		require.NoError(t, err)
+		require.Equal(t, 0, len(tr.Orders()))
+	})
+
+	t.Run("day expires at the end of the day", func(t *testing.T) {
+		clock := NewManualClock(tradingDay + 500)
+		ob := NewOrderBook(WithClock(clock))
+		order := Order{ID: 1, Price: decimal.NewFromFloat(5.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection, TimeInForce: DayTimeInForce}
+		submitOrder(t, ob, order)
+		_, el, ok := ob.find(1)
+		require.True(t, ok)
+		require.Equal(t, 2*tradingDay, el.Value.(*Order).ExpireAt)
+
+		tr, err := ob.ExpireOrders(2*tradingDay - 1)
+		require.NoError(t, err)
+		require.Equal(t, 0, len(tr.Orders()))
+		tr, err = ob.ExpireOrders(2 * tradingDay)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(tr.Orders()))
+	})
+
+	t.Run("filled order is not expired", func(t *testing.T) {
+		ob := init(t)
+		submitOrder(t, ob, Order{ID: 3, Price: decimal.NewFromFloat(5.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection, TimeInForce: GTDTimeInForce, ExpireAt: 1000})
+		submitOrder(t, ob, Order{ID: 4, Price: decimal.NewFromFloat(5.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: SellOrderDirection})
+
+		tr, err := ob.ExpireOrders(1000)
+		require.NoError(t, err)
+		require.Equal(t, 0, len(tr.Orders()))
+	})
+}
+
+func TestIcebergOrders(t *testing.T) {
+	ids := func(q *OrderQueue) []OrderID {
+		a := make([]OrderID, 0)
+		for e := q.orders.Front(); e != nil; e = e.Next() {
+			a = append(a, e.Value.(*Order).ID)
+		}
+		return a
+	}
+
+	init := func(t *testing.T) *OrderBook {
+		ob := NewOrderBook()
+		sell := []Order{
+			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Display: decimal.NewFromFloat(30.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(50.0), Type: LimitOrderType, Dir: SellOrderDirection},
+		}
+		for _, v := range sell {
+			submitOrder(t, ob, v)
+		}
+		return ob
+	}
+
+	t.Run("only tip is visible", func(t *testing.T) {
+		ob := init(t)
+		queue := getQueues(ob.sell)[0]
+		require.True(t, decimal.NewFromFloat(80.0).Equal(queue.Volume()))
+		require.True(t, decimal.NewFromFloat(150.0).Equal(queue.TotalVolume()))
+		require.True(t, decimal.NewFromFloat(80.0).Equal(ob.sell.Volume()))
+		require.True(t, decimal.NewFromFloat(150.0).Equal(ob.sell.TotalVolume()))
+	})
+
+	t.Run("partial tip fill keeps priority", func(t *testing.T) {
+		ob := init(t)
+		trades := submitTrades(t, ob, Order{ID: 3, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(20.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.Equal(t, 1, len(trades))
+		require.Equal(t, OrderID(1), trades[0].Maker)
+
+		queue := getQueues(ob.sell)[0]
+		require.Equal(t, []OrderID{1, 2}, ids(queue))
+		require.True(t, decimal.NewFromFloat(60.0).Equal(queue.Volume()))
+		require.True(t, decimal.NewFromFloat(130.0).Equal(ob.sell.TotalVolume()))
+	})
+
+	t.Run("replenished tip goes to the back", func(t *testing.T) {
+		ob := init(t)
+		trades := submitTrades(t, ob, Order{ID: 3, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(40.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.Equal(t, 2, len(trades))
+		require.Equal(t, OrderID(1), trades[0].Maker)
+		require.True(t, decimal.NewFromFloat(30.0).Equal(trades[0].Amount))
+		require.Equal(t, OrderID(2), trades[1].Maker)
+		require.True(t, decimal.NewFromFloat(10.0).Equal(trades[1].Amount))
+
+		queue := getQueues(ob.sell)[0]
+		require.Equal(t, []OrderID{2, 1}, ids(queue))
+		iceberg := queue.orders.Back().Value.(*Order)
+		require.True(t, decimal.NewFromFloat(70.0).Equal(iceberg.Amount))
+		require.True(t, decimal.NewFromFloat(30.0).Equal(iceberg.tip))
+		require.True(t, decimal.NewFromFloat(70.0).Equal(queue.Volume()))
+		require.True(t, decimal.NewFromFloat(110.0).Equal(queue.TotalVolume()))
+		require.True(t, decimal.NewFromFloat(70.0).Equal(ob.sell.Volume()))
+		require.True(t, decimal.NewFromFloat(110.0).Equal(ob.sell.TotalVolume()))
+	})
+
+	t.Run("hidden amount is matched after the queue", func(t *testing.T) {
+		ob := init(t)
+		trades := submitTrades(t, ob, Order{ID: 3, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(120.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+
+		makers := make([]OrderID, 0)
+		for _, tr := range trades {
+			makers = append(makers, tr.Maker)
+		}
+		require.Equal(t, []OrderID{1, 2, 1, 1}, makers)
+		require.True(t, decimal.NewFromFloat(10.0).Equal(trades[3].Amount))
+
+		queue := getQueues(ob.sell)[0]
+		require.Equal(t, []OrderID{1}, ids(queue))
+		require.True(t, decimal.NewFromFloat(20.0).Equal(queue.Volume()))
+		require.True(t, decimal.NewFromFloat(30.0).Equal(queue.TotalVolume()))
+		require.True(t, decimal.NewFromFloat(20.0).Equal(ob.sell.Volume()))
+		require.True(t, decimal.NewFromFloat(30.0).Equal(ob.sell.TotalVolume()))
+	})
+
+	t.Run("fully consumed", func(t *testing.T) {
+		ob := init(t)
+		submitOrder(t, ob, Order{ID: 3, Price: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: SellOrderDirection})
+		trades := submitTrades(t, ob, Order{ID: 4, Price: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(155.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.Equal(t, 6, len(trades))
+
+		queues := getQueues(ob.sell)
+		require.Equal(t, 1, len(queues))
+		require.True(t, decimal.NewFromFloat(5.0).Equal(ob.sell.Volume()))
+		require.True(t, decimal.NewFromFloat(5.0).Equal(ob.sell.TotalVolume()))
+	})
+
+	t.Run("tip is not taken from the caller", func(t *testing.T) {
+		ob := NewOrderBook()
+		submitOrder(t, ob, Order{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(10.0), Display: decimal.NewFromFloat(5.0), tip: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection})
+		require.True(t, decimal.NewFromFloat(5.0).Equal(ob.sell.Volume()))
+
+		trades := submitTrades(t, ob, Order{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(50.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.Equal(t, 2, len(trades))
+		require.True(t, decimal.NewFromFloat(5.0).Equal(trades[0].Amount))
+		require.True(t, decimal.NewFromFloat(5.0).Equal(trades[1].Amount))
+		require.Equal(t, 0, len(getQueues(ob.sell)))
+		require.True(t, decimal.NewFromFloat(40.0).Equal(ob.buy.TotalVolume()))
+	})
+
+	t.Run("amend and cancel", func(t *testing.T) {
+		ob := init(t)
+		tr, err := ob.AmendOrder(1, decimal.NewFromFloat(10.0), decimal.NewFromFloat(20.0))
+		require.NoError(t, err)
+		_, err = tr.Commit()
+		require.NoError(t, err)
+		require.True(t, decimal.NewFromFloat(70.0).Equal(ob.sell.Volume()))
+		require.True(t, decimal.NewFromFloat(70.0).Equal(ob.sell.TotalVolume()))
+
+		tr, err = ob.CancelOrder(1)
+		require.NoError(t, err)
+		_, err = tr.Commit()
+		require.NoError(t, err)
+		require.True(t, decimal.NewFromFloat(50.0).Equal(ob.sell.Volume()))
+		require.True(t, decimal.NewFromFloat(50.0).Equal(ob.sell.TotalVolume()))
+	})
+}
+
+func TestExactFill(t *testing.T) {
+	ob := NewOrderBook()
+	sell := []Order{
+		{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
+		{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: SellOrderDirection},
+	}
+	for _, v := range sell {
+		submitOrder(t, ob, v)
+	}
+
+	trades := submitTrades(t, ob, Order{ID: 3, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+	require.Equal(t, 1, len(trades))
+	require.Equal(t, OrderID(1), trades[0].Maker)
+	require.True(t, decimal.NewFromFloat(150.0).Equal(ob.sell.Volume()))
+}
+
+func TestPostOnlyOrders(t *testing.T) {
+	init := func(t *testing.T, opts ...OrderBookOption) *OrderBook {
+		ob := NewOrderBook(opts...)
+		orders := []Order{
+			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 2, Price: decimal.NewFromFloat(9.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+		}
+		for _, v := range orders {
+			submitOrder(t, ob, v)
+		}
+		return ob
+	}
+
+	t.Run("rests when not crossing", func(t *testing.T) {
+		ob := init(t)
+		trades := submitTrades(t, ob, Order{ID: 3, Price: decimal.NewFromFloat(9.5), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection, PostOnly: RejectPostOnly})
+		require.Equal(t, 0, len(trades))
+		require.Equal(t, 2, len(getQueues(ob.buy)))
+	})
+
+	t.Run("rejected when crossing", func(t *testing.T) {
+		ob := init(t)
+		_, err := ob.SubmitOrder(&Order{ID: 3, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection, PostOnly: RejectPostOnly})
+		require.ErrorIs(t, err, ErrPostOnly)
+		_, err = ob.SubmitOrder(&Order{ID: 3, Price: decimal.NewFromFloat(8.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: SellOrderDirection, PostOnly: RejectPostOnly})
+		require.ErrorIs(t, err, ErrPostOnly)
+
+		// repricing needs a tick size
+		_, err = ob.SubmitOrder(&Order{ID: 3, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection, PostOnly: RepricePostOnly})
+		require.ErrorIs(t, err, ErrPostOnly)
+
+		require.True(t, decimal.NewFromFloat(100.0).Equal(ob.sell.Volume()))
+		require.True(t, decimal.NewFromFloat(100.0).Equal(ob.buy.Volume()))
+	})
+
+	t.Run("repriced when crossing", func(t *testing.T) {
+		ob := init(t, WithTickSize(decimal.NewFromFloat(0.1)))
+
+		buy := Order{ID: 3, Price: decimal.NewFromFloat(12.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection, PostOnly: RepricePostOnly}
+		require.Equal(t, 0, len(submitTrades(t, ob, buy)))
+		sell := Order{ID: 4, Price: decimal.NewFromFloat(8.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: SellOrderDirection, PostOnly: RepricePostOnly}
+		require.Equal(t, 0, len(submitTrades(t, ob, sell)))
+
+		bids := getQueues(ob.buy)
+		require.Equal(t, 2, len(bids))
+		require.True(t, decimal.NewFromFloat(9.9).Equal(bids[1].Price()))
+		// one tick above the repriced bid
+		asks := getQueues(ob.sell)
+		require.Equal(t, 1, len(asks))
+		require.True(t, decimal.NewFromFloat(10.0).Equal(asks[0].Price()))
+		require.True(t, decimal.NewFromFloat(110.0).Equal(asks[0].Volume()))
+	})
+}
+
+func TestSelfTradePrevention(t *testing.T) {
+	ids := func(q *OrderQueue) []OrderID {
+		a := make([]OrderID, 0)
+		for e := q.orders.Front(); e != nil; e = e.Next() {
+			a = append(a, e.Value.(*Order).ID)
+		}
+		return a
+	}
+
+	init := func(t *testing.T) *OrderBook {
+		ob := NewOrderBook()
+		sell := []Order{
+			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection, Owner: "alice"},
+			{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(50.0), Type: LimitOrderType, Dir: SellOrderDirection, Owner: "bob"},
+			{ID: 3, Price: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection, Owner: "alice"},
+		}
+		for _, v := range sell {
+			submitOrder(t, ob, v)
+		}
+		return ob
+	}
+
+	submit := func(t *testing.T, ob *OrderBook, o Order) Transaction {
+		tr, err := ob.SubmitOrder(&o)
+		require.NoError(t, err)
+		_, err = tr.Commit()
+		require.NoError(t, err)
+		return tr
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		ob := init(t)
+		tr := submit(t, ob, Order{ID: 4, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: BuyOrderDirection, Owner: "alice"})
+		require.Equal(t, 1, len(tr.trades))
+		require.Equal(t, 0, len(tr.Prevented()))
+	})
+
+	t.Run("cancel newest", func(t *testing.T) {
+		ob := init(t)
+		buy := Order{ID: 4, Price: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: BuyOrderDirection, Owner: "alice", STP: CancelNewestSTPMode}
+		tr := submit(t, ob, buy)
+
+		require.Equal(t, 0, len(tr.trades))
+		require.Equal(t, []SelfTrade{{Amount: decimal.NewFromFloat(60.0), Maker: 1, Taker: 4, Mode: CancelNewestSTPMode}}, tr.Prevented())
+		require.Equal(t, 1, len(tr.Orders()))
+		require.Equal(t, OrderID(4), tr.Orders()[0].ID)
+
+		require.Equal(t, 0, len(getQueues(ob.buy)))
+		require.True(t, decimal.NewFromFloat(250.0).Equal(ob.sell.Volume()))
+	})
+
+	t.Run("cancel oldest", func(t *testing.T) {
+		ob := init(t)
+		tr := submit(t, ob, Order{ID: 4, Price: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: BuyOrderDirection, Owner: "alice", STP: CancelOldestSTPMode})
+
+		require.Equal(t, 1, len(tr.trades))
+		require.Equal(t, OrderID(2), tr.trades[0].Maker)
+		require.True(t, decimal.NewFromFloat(50.0).Equal(tr.trades[0].Amount))
+
+		// both alice orders are cancelled on the way
+		require.Equal(t, 2, len(tr.Prevented()))
+		require.Equal(t, OrderID(1), tr.Prevented()[0].Maker)
+		require.True(t, decimal.NewFromFloat(60.0).Equal(tr.Prevented()[0].Amount))
+		require.Equal(t, OrderID(3), tr.Prevented()[1].Maker)
+		require.True(t, decimal.NewFromFloat(10.0).Equal(tr.Prevented()[1].Amount))
+
+		require.Equal(t, 0, len(getQueues(ob.sell)))
+		require.True(t, ob.sell.Volume().IsZero())
+
+		queues := getQueues(ob.buy)
+		require.Equal(t, 1, len(queues))
+		require.True(t, decimal.NewFromFloat(10.0).Equal(queues[0].Volume()))
+		require.True(t, decimal.NewFromFloat(10.0).Equal(ob.buy.Volume()))
+	})
+
+	t.Run("cancel both", func(t *testing.T) {
+		ob := init(t)
+		tr := submit(t, ob, Order{ID: 4, Price: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: BuyOrderDirection, Owner: "alice", STP: CancelBothSTPMode})
+
+		require.Equal(t, 0, len(tr.trades))
+		require.Equal(t, 1, len(tr.Prevented()))
+		require.Equal(t, 0, len(getQueues(ob.buy)))
+
+		queues := getQueues(ob.sell)
+		require.Equal(t, []OrderID{2}, ids(queues[0]))
+		require.True(t, decimal.NewFromFloat(150.0).Equal(ob.sell.Volume()))
+	})
+
+	t.Run("decrement and cancel", func(t *testing.T) {
+		ob := init(t)
+		tr := submit(t, ob, Order{ID: 4, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: BuyOrderDirection, Owner: "alice", STP: DecrementSTPMode})
+		require.Equal(t, 0, len(tr.trades))
+		require.Equal(t, 1, len(tr.Prevented()))
+
+		queues := getQueues(ob.sell)
+		require.Equal(t, []OrderID{1, 2}, ids(queues[0]))
+		require.True(t, decimal.NewFromFloat(40.0).Equal(queues[0].orders.Front().Value.(*Order).Amount))
+		require.True(t, decimal.NewFromFloat(90.0).Equal(queues[0].Volume()))
+		require.True(t, decimal.NewFromFloat(190.0).Equal(ob.sell.Volume()))
+		require.Equal(t, 0, len(getQueues(ob.buy)))
+
+		tr = submit(t, ob, Order{ID: 5, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: BuyOrderDirection, Owner: "alice", STP: DecrementSTPMode})
+		require.Equal(t, 1, len(tr.trades))
+		require.Equal(t, OrderID(2), tr.trades[0].Maker)
+		require.True(t, decimal.NewFromFloat(20.0).Equal(tr.trades[0].Amount))
+
+		queues = getQueues(ob.sell)
+		require.Equal(t, 2, len(queues))
+		require.Equal(t, []OrderID{2}, ids(queues[0]))
+		require.True(t, decimal.NewFromFloat(130.0).Equal(ob.sell.Volume()))
+	})
+
+	t.Run("fill or kill", func(t *testing.T) {
+		ob := init(t)
+		_, err := ob.SubmitOrder(&Order{ID: 4, Price: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: BuyOrderDirection, Owner: "alice", STP: CancelNewestSTPMode, TimeInForce: FOKTimeInForce})
+		require.ErrorIs(t, err, ErrOrderKilled)
+	})
+}
+
+func TestMarketOrderRemainder(t *testing.T) {
+	init := func(t *testing.T) *OrderBook {
+		ob := NewOrderBook()
+		sell := []Order{
+			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 2, Price: decimal.NewFromFloat(15.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
+		}
+		for _, v := range sell {
+			submitOrder(t, ob, v)
+		}
+		return ob
+	}
+
+	t.Run("partial fill", func(t *testing.T) {
+		ob := init(t)
+		buy := Order{ID: 3, Price: decimal.NewFromFloat(15.0), Amount: decimal.NewFromFloat(250.0), Type: MarketOrderType, Dir: BuyOrderDirection}
+		tr, err := ob.SubmitOrder(&buy)
+		require.NoError(t, err)
+		trades, err := tr.Commit()
+		require.NoError(t, err)
+
+		require.Equal(t, 2, len(trades))
+		require.Equal(t, 3, len(tr.Orders()))
+		require.Equal(t, &buy, tr.Orders()[2])
+		require.Equal(t, []Cancellation{{Amount: decimal.NewFromFloat(50.0), ID: 3}}, tr.Cancelled())
+
+		require.Equal(t, 0, len(getQueues(ob.sell)))
+		require.Equal(t, 0, len(getQueues(ob.buy)))
+		require.True(t, ob.sell.Volume().IsZero())
+	})
+
+	t.Run("empty book", func(t *testing.T) {
+		ob := NewOrderBook()
+		tr, err := ob.SubmitOrder(&Order{ID: 1, Price: decimal.NewFromFloat(15.0), Amount: decimal.NewFromFloat(250.0), Type: MarketOrderType, Dir: SellOrderDirection})
+		require.NoError(t, err)
+		trades, err := tr.Commit()
+		require.NoError(t, err)
+
+		require.Equal(t, 0, len(trades))
+		require.Equal(t, []Cancellation{{Amount: decimal.NewFromFloat(250.0), ID: 1}}, tr.Cancelled())
+		require.Equal(t, 0, len(getQueues(ob.sell)))
+	})
+
+	t.Run("fill or kill", func(t *testing.T) {
+		ob := init(t)
+		_, err := ob.SubmitOrder(&Order{ID: 3, Price: decimal.NewFromFloat(15.0), Amount: decimal.NewFromFloat(250.0), Type: MarketOrderType, Dir: BuyOrderDirection, TimeInForce: FOKTimeInForce})
+		require.ErrorIs(t, err, ErrOrderKilled)
+		require.True(t, decimal.NewFromFloat(200.0).Equal(ob.sell.Volume()))
+
+		trades := submitTrades(t, ob, Order{ID: 4, Price: decimal.NewFromFloat(15.0), Amount: decimal.NewFromFloat(200.0), Type: MarketOrderType, Dir: BuyOrderDirection, TimeInForce: FOKTimeInForce})
+		require.Equal(t, 2, len(trades))
+		require.True(t, ob.sell.Volume().IsZero())
+	})
+
+	t.Run("ioc limit remainder", func(t *testing.T) {
+		ob := init(t)
+		tr, err := ob.SubmitOrder(&Order{ID: 3, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: BuyOrderDirection, TimeInForce: IOCTimeInForce})
+		require.NoError(t, err)
+		_, err = tr.Commit()
+		require.NoError(t, err)
+		require.Equal(t, []Cancellation{{Amount: decimal.NewFromFloat(50.0), ID: 3}}, tr.Cancelled())
+	})
+}
+
+func TestMarketOrderProtection(t *testing.T) {
+	init := func(t *testing.T, opts ...OrderBookOption) *OrderBook {
+		ob := NewOrderBook(opts...)
+		sell := []Order{
+			{ID: 1, Price: decimal.NewFromFloat(100.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 2, Price: decimal.NewFromFloat(101.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 3, Price: decimal.NewFromFloat(103.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 4, Price: decimal.NewFromFloat(99.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+			{ID: 5, Price: decimal.NewFromFloat(95.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+		}
+		for _, v := range sell {
+			submitOrder(t, ob, v)
+		}
+		return ob
+	}
+
+	submit := func(t *testing.T, ob *OrderBook, o Order) Transaction {
+		tr, err := ob.SubmitOrder(&o)
+		require.NoError(t, err)
+		_, err = tr.Commit()
+		require.NoError(t, err)
+		return tr
+	}
+
+	t.Run("protection price", func(t *testing.T) {
+		ob := init(t)
+		tr := submit(t, ob, Order{ID: 6, Price: decimal.NewFromFloat(1.0), Amount: decimal.NewFromFloat(30.0), Protection: decimal.NewFromFloat(102.0), Type: MarketOrderType, Dir: BuyOrderDirection})
+
+		require.Equal(t, 2, len(tr.trades))
+		require.Equal(t, []Cancellation{{Amount: decimal.NewFromFloat(10.0), ID: 6}}, tr.Cancelled())
+		require.True(t, decimal.NewFromFloat(10.0).Equal(ob.sell.Volume()))
+	})
+
+	t.Run("slippage in ticks", func(t *testing.T) {
+		ob := init(t, WithTickSize(decimal.NewFromFloat(0.5)))
+		tr := submit(t, ob, Order{ID: 6, Price: decimal.NewFromFloat(1.0), Amount: decimal.NewFromFloat(30.0), MaxSlippage: decimal.NewFromInt(2), SlippageType: TickSlippageType, Type: MarketOrderType, Dir: BuyOrderDirection})
+
+		require.Equal(t, 2, len(tr.trades))
+		require.True(t, decimal.NewFromFloat(101.0).Equal(tr.trades[1].Price))
+		require.Equal(t, 1, len(tr.Cancelled()))
+	})
+
+	t.Run("slippage in percent", func(t *testing.T) {
+		ob := init(t)
+		tr := submit(t, ob, Order{ID: 6, Price: decimal.NewFromFloat(1.0), Amount: decimal.NewFromFloat(30.0), MaxSlippage: decimal.NewFromInt(5), SlippageType: PercentSlippageType, Type: MarketOrderType, Dir: SellOrderDirection})
+
+		// 99 * 0.95 = 94.05, both bid levels are reachable
+		require.Equal(t, 2, len(tr.trades))
+		require.Equal(t, []Cancellation{{Amount: decimal.NewFromFloat(10.0), ID: 6}}, tr.Cancelled())
+
+		ob = init(t)
+		tr = submit(t, ob, Order{ID: 6, Price: decimal.NewFromFloat(1.0), Amount: decimal.NewFromFloat(30.0), MaxSlippage: decimal.NewFromInt(1), SlippageType: PercentSlippageType, Type: MarketOrderType, Dir: SellOrderDirection})
+		require.Equal(t, 1, len(tr.trades))
+		require.True(t, decimal.NewFromFloat(10.0).Equal(ob.buy.Volume()))
+	})
+
+	t.Run("tighter limit wins", func(t *testing.T) {
+		ob := init(t)
+		tr := submit(t, ob, Order{ID: 6, Price: decimal.NewFromFloat(1.0), Amount: decimal.NewFromFloat(30.0), Protection: decimal.NewFromFloat(100.0), MaxSlippage: decimal.NewFromInt(5), SlippageType: PercentSlippageType, Type: MarketOrderType, Dir: BuyOrderDirection})
+		require.Equal(t, 1, len(tr.trades))
+	})
+
+	t.Run("bad values", func(t *testing.T) {
+		ob := init(t)
+		_, err := ob.SubmitOrder(&Order{ID: 6, Price: decimal.NewFromFloat(1.0), Amount: decimal.NewFromFloat(30.0), MaxSlippage: decimal.NewFromInt(2), SlippageType: TickSlippageType, Type: MarketOrderType, Dir: BuyOrderDirection})
+		require.ErrorIs(t, err, ErrBadSlippage)
+		_, err = ob.SubmitOrder(&Order{ID: 6, Price: decimal.NewFromFloat(1.0), Amount: decimal.NewFromFloat(30.0), MaxSlippage: decimal.NewFromInt(-2), SlippageType: PercentSlippageType, Type: MarketOrderType, Dir: BuyOrderDirection})
+		require.ErrorIs(t, err, ErrBadSlippage)
+		_, err = ob.SubmitOrder(&Order{ID: 6, Price: decimal.NewFromFloat(1.0), Amount: decimal.NewFromFloat(30.0), Protection: decimal.NewFromInt(-2), Type: MarketOrderType, Dir: BuyOrderDirection})
+		require.ErrorIs(t, err, ErrBadPrice)
+	})
+}
+
+func TestQuoteMarketOrders(t *testing.T) {
+	init := func(t *testing.T, opts ...OrderBookOption) *OrderBook {
+		ob := NewOrderBook(opts...)
+		sell := []Order{
+			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(20.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 2, Price: decimal.NewFromFloat(12.0), Amount: decimal.NewFromFloat(50.0), Type: LimitOrderType, Dir: SellOrderDirection},
+		}
+		for _, v := range sell {
+			submitOrder(t, ob, v)
+		}
+		return ob
+	}
+
+	submit := func(t *testing.T, ob *OrderBook, o Order) Transaction {
+		tr, err := ob.SubmitOrder(&o)
+		require.NoError(t, err)
+		_, err = tr.Commit()
+		require.NoError(t, err)
+		return tr
+	}
+
+	t.Run("spend notional across levels", func(t *testing.T) {
+		ob := init(t)
+		tr := submit(t, ob, Order{ID: 3, Price: decimal.NewFromFloat(1.0), Notional: decimal.NewFromFloat(500.0), Type: MarketOrderType, Dir: BuyOrderDirection})
+
+		require.Equal(t, 2, len(tr.trades))
+		require.True(t, decimal.NewFromFloat(20.0).Equal(tr.trades[0].Amount))
+		require.True(t, decimal.NewFromFloat(25.0).Equal(tr.trades[1].Amount))
+		require.Equal(t, 0, len(tr.Cancelled()))
+		require.True(t, decimal.NewFromFloat(25.0).Equal(ob.sell.Volume()))
+	})
+
+	t.Run("fractional fill is rounded", func(t *testing.T) {
+		ob := init(t, WithAmountRounding(2, DownRoundingMode))
+		tr := submit(t, ob, Order{ID: 3, Price: decimal.NewFromFloat(1.0), Notional: decimal.NewFromFloat(300.0), Type: MarketOrderType, Dir: BuyOrderDirection})
+
+		// 100 / 12 = 8.333..
+		require.Equal(t, 2, len(tr.trades))
+		require.True(t, decimal.RequireFromString("8.33").Equal(tr.trades[1].Amount))
+		require.Equal(t, 1, len(tr.Cancelled()))
+		require.True(t, decimal.RequireFromString("0.04").Equal(tr.Cancelled()[0].Amount))
+
+		ob = init(t, WithAmountRounding(2, UpRoundingMode))
+		tr = submit(t, ob, Order{ID: 3, Price: decimal.NewFromFloat(1.0), Notional: decimal.NewFromFloat(300.0), Type: MarketOrderType, Dir: BuyOrderDirection})
+		require.True(t, decimal.RequireFromString("8.34").Equal(tr.trades[1].Amount))
+		require.Equal(t, 0, len(tr.Cancelled()))
+	})
+
+	t.Run("not enough liquidity", func(t *testing.T) {
+		ob := init(t)
+		tr := submit(t, ob, Order{ID: 3, Price: decimal.NewFromFloat(1.0), Notional: decimal.NewFromFloat(1000.0), Type: MarketOrderType, Dir: BuyOrderDirection})
+
+		require.Equal(t, 2, len(tr.trades))
+		require.Equal(t, 1, len(tr.Cancelled()))
+		require.True(t, decimal.NewFromFloat(200.0).Equal(tr.Cancelled()[0].Amount))
+		require.True(t, ob.sell.Volume().IsZero())
+	})
+
+	t.Run("sell notional", func(t *testing.T) {
+		ob := NewOrderBook()
+		submitOrder(t, ob, Order{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(20.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		tr := submit(t, ob, Order{ID: 2, Price: decimal.NewFromFloat(1.0), Notional: decimal.NewFromFloat(50.0), Type: MarketOrderType, Dir: SellOrderDirection})
+
+		require.Equal(t, 1, len(tr.trades))
+		require.True(t, decimal.NewFromFloat(5.0).Equal(tr.trades[0].Amount))
+	})
+
+	t.Run("bad values", func(t *testing.T) {
+		ob := init(t)
+		_, err := ob.SubmitOrder(&Order{ID: 3, Price: decimal.NewFromFloat(1.0), Notional: decimal.NewFromFloat(-1.0), Type: MarketOrderType, Dir: BuyOrderDirection})
+		require.ErrorIs(t, err, ErrBadNotional)
+		_, err = ob.SubmitOrder(&Order{ID: 3, Price: decimal.NewFromFloat(1.0), Notional: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.ErrorIs(t, err, ErrBadNotional)
+		_, err = ob.SubmitOrder(&Order{ID: 3, Price: decimal.NewFromFloat(1.0), Type: MarketOrderType, Dir: BuyOrderDirection})
+		require.ErrorIs(t, err, ErrBadAmount)
+	})
+}
+
+func TestStaleTransaction(t *testing.T) {
+	init := func(t *testing.T) *OrderBook {
+		ob := NewOrderBook()
+		submitOrder(t, ob, Order{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection})
+		return ob
+	}
+
+	t.Run("second commit is rejected", func(t *testing.T) {
+		ob := init(t)
+		first, err := ob.SubmitOrder(&Order{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.NoError(t, err)
+		second, err := ob.CancelOrder(1)
+		require.NoError(t, err)
+
+		_, err = first.Commit()
+		require.NoError(t, err)
+		_, err = second.Commit()
+		require.ErrorIs(t, err, ErrStaleTransaction)
+
+		require.True(t, ob.sell.Volume().IsZero())
+		require.True(t, ob.buy.Volume().IsZero())
+		require.Equal(t, 0, len(ob.sell.index))
+	})
+
+	t.Run("rollback keeps others fresh", func(t *testing.T) {
+		ob := init(t)
+		first, err := ob.SubmitOrder(&Order{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(40.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.NoError(t, err)
+		second, err := ob.SubmitOrder(&Order{ID: 3, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(30.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.NoError(t, err)
+
+		require.NoError(t, first.Rollback())
+		trades, err := second.Commit()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(trades))
+		require.True(t, decimal.NewFromFloat(70.0).Equal(ob.sell.Volume()))
+	})
+
+	t.Run("reevaluated after stale", func(t *testing.T) {
+		ob := init(t)
+		order := Order{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: BuyOrderDirection}
+		o := order
+		stale, err := ob.SubmitOrder(&o)
+		require.NoError(t, err)
+		submitOrder(t, ob, Order{ID: 3, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+
+		_, err = stale.Commit()
+		require.ErrorIs(t, err, ErrStaleTransaction)
+
+		// only 40 left to match after the other order
+		o = order
+		trades := submitTrades(t, ob, o)
+		require.Equal(t, 1, len(trades))
+		require.True(t, decimal.NewFromFloat(40.0).Equal(trades[0].Amount))
+		require.True(t, decimal.NewFromFloat(20.0).Equal(ob.buy.Volume()))
+	})
+}
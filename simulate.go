@@ -0,0 +1,95 @@
+package main
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// Simulation is what an order would do to the book right now, Slippage is the average price
+// distance from the mid price in percent, positive when worse for the order
+type Simulation struct {
+	Trades     []Trade         `json:"trades"`
+	Filled     decimal.Decimal `json:"filled"`
+	Notional   decimal.Decimal `json:"notional"`
+	AvgPrice   decimal.Decimal `json:"avg_price"`
+	WorstPrice decimal.Decimal `json:"worst_price"`
+	MidPrice   decimal.Decimal `json:"mid_price"`
+	Slippage   decimal.Decimal `json:"slippage"`
+	Remaining  decimal.Decimal `json:"remaining"` // in quote currency for quote orders
+}
+
+// Simulate matches a copy of order against the book without building finalizers, the book is not changed.
+// Stop orders are simulated as if they were triggered, trades have no IDs
+func (ob *OrderBook) Simulate(order *Order) (Simulation, error) {
+	if err := validate(order); err != nil {
+		return Simulation{}, err
+	}
+	if err := ob.instrument.check(order); err != nil {
+		return Simulation{}, err
+	}
+
+	o := *order
+	switch o.Type {
+	case StopOrderType:
+		o.Type = MarketOrderType
+	case StopLimitOrderType:
+		o.Type = LimitOrderType
+	}
+
+	var limit *decimal.Decimal
+	if o.Type == MarketOrderType {
+		var err error
+		if limit, err = ob.protectionPrice(&o); err != nil {
+			return Simulation{}, err
+		}
+	} else {
+		limit = &o.Price
+		if best, ok := ob.crosses(&o); ok && o.PostOnly != NoPostOnly {
+			if _, err := ob.repricedPrice(&o, best); err != nil {
+				return Simulation{}, err
+			}
+			// repriced orders only rest on the book
+			return ob.simulation(&o, matching{left: o.Amount}), nil
+		}
+	}
+
+	m := ob.match(&o, limit, true)
+	if o.TimeInForce == FOKTimeInForce && (m.left.Sign() > 0 || m.takerCancelled) {
+		return Simulation{}, ErrOrderKilled
+	}
+	return ob.simulation(&o, m), nil
+}
+
+func (ob *OrderBook) simulation(order *Order, m matching) Simulation {
+	sim := Simulation{
+		Trades:    m.trades,
+		Filled:    decimal.Zero,
+		Notional:  decimal.Zero,
+		Remaining: m.left,
+	}
+	if sim.Trades == nil {
+		sim.Trades = make([]Trade, 0)
+	}
+
+	for _, t := range sim.Trades {
+		sim.Filled = sim.Filled.Add(t.Amount)
+		sim.Notional = sim.Notional.Add(t.Amount.Mul(t.Price))
+		// levels are swept best to worst
+		sim.WorstPrice = t.Price
+	}
+	if sim.Filled.IsZero() {
+		return sim
+	}
+	sim.AvgPrice = sim.Notional.Div(sim.Filled)
+
+	mid, ok := ob.MidPrice()
+	if !ok {
+		return sim
+	}
+	sim.MidPrice = mid
+	diff := sim.AvgPrice.Sub(mid)
+	if order.Dir == SellOrderDirection {
+		diff = diff.Neg()
+	}
+	sim.Slippage = diff.Mul(decimal.NewFromInt(100)).Div(mid)
+	return sim
+}
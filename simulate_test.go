@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulate(t *testing.T) {
+	init := func(t *testing.T) *OrderBook {
+		ob := NewOrderBook()
+		orders := []Order{
+			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(50.0), Display: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 2, Price: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(50.0), Type: LimitOrderType, Dir: SellOrderDirection, Owner: "a"},
+			{ID: 3, Price: decimal.NewFromFloat(12.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 4, Price: decimal.NewFromFloat(8.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+		}
+		for _, v := range orders {
+			submitOrder(t, ob, v)
+		}
+		return ob
+	}
+
+	t.Run("market buy", func(t *testing.T) {
+		ob := init(t)
+		before := ob.Checksum()
+		order := &Order{ID: 5, Price: decimal.NewFromFloat(1.0), Amount: decimal.NewFromFloat(120.0), Type: MarketOrderType, Dir: BuyOrderDirection}
+
+		sim, err := ob.Simulate(order)
+		require.NoError(t, err)
+		require.Equal(t, before, ob.Checksum())
+
+		// iceberg reserve is matched as well
+		require.Equal(t, 7, len(sim.Trades))
+		require.True(t, decimal.NewFromFloat(120.0).Equal(sim.Filled))
+		require.True(t, decimal.NewFromFloat(1290.0).Equal(sim.Notional))
+		require.True(t, decimal.NewFromFloat(10.75).Equal(sim.AvgPrice))
+		require.True(t, decimal.NewFromFloat(12.0).Equal(sim.WorstPrice))
+		require.True(t, decimal.NewFromFloat(9.0).Equal(sim.MidPrice))
+		// (10.75 - 9) / 9
+		require.True(t, decimal.RequireFromString("19.4444444444444444").Equal(sim.Slippage))
+		require.True(t, sim.Remaining.IsZero())
+
+		// simulation matches what a real submit does
+		trades := submitTrades(t, ob, *order)
+		for i := range trades {
+			trades[i].ID = 0
+			trades[i].Timestamp = 0
+		}
+		require.Equal(t, trades, sim.Trades)
+	})
+
+	t.Run("limit sell", func(t *testing.T) {
+		ob := init(t)
+		sim, err := ob.Simulate(&Order{ID: 5, Price: decimal.NewFromFloat(7.0), Amount: decimal.NewFromFloat(150.0), Type: LimitOrderType, Dir: SellOrderDirection})
+		require.NoError(t, err)
+		require.True(t, decimal.NewFromFloat(100.0).Equal(sim.Filled))
+		require.True(t, decimal.NewFromFloat(50.0).Equal(sim.Remaining))
+		require.True(t, decimal.RequireFromString("11.1111111111111111").Equal(sim.Slippage))
+	})
+
+	t.Run("quote and self trade", func(t *testing.T) {
+		ob := init(t)
+		sim, err := ob.Simulate(&Order{ID: 5, Price: decimal.NewFromFloat(1.0), Notional: decimal.NewFromFloat(1000.0), Type: MarketOrderType, Dir: BuyOrderDirection, Owner: "a", STP: CancelNewestSTPMode})
+		require.NoError(t, err)
+		require.True(t, decimal.NewFromFloat(50.0).Equal(sim.Filled))
+		require.True(t, decimal.NewFromFloat(500.0).Equal(sim.Remaining))
+	})
+
+	t.Run("no liquidity", func(t *testing.T) {
+		ob := NewOrderBook()
+		sim, err := ob.Simulate(&Order{ID: 1, Price: decimal.NewFromFloat(1.0), Amount: decimal.NewFromFloat(10.0), Type: MarketOrderType, Dir: BuyOrderDirection})
+		require.NoError(t, err)
+		require.Equal(t, 0, len(sim.Trades))
+		require.True(t, sim.AvgPrice.IsZero())
+		require.True(t, decimal.NewFromFloat(10.0).Equal(sim.Remaining))
+	})
+
+	t.Run("repriced post only", func(t *testing.T) {
+		ob := NewOrderBook(WithTickSize(decimal.NewFromFloat(1.0)))
+		submitOrder(t, ob, Order{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(50.0), Type: LimitOrderType, Dir: SellOrderDirection})
+
+		sim, err := ob.Simulate(&Order{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection, PostOnly: RepricePostOnly})
+		require.NoError(t, err)
+		require.Equal(t, 0, len(sim.Trades))
+		require.True(t, decimal.NewFromFloat(10.0).Equal(sim.Remaining))
+
+		_, err = ob.Simulate(&Order{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection, PostOnly: RepricePostOnly, TimeInForce: IOCTimeInForce})
+		require.ErrorIs(t, err, ErrPostOnly)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		ob := init(t)
+		_, err := ob.Simulate(&Order{ID: 5, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(200.0), Type: LimitOrderType, Dir: BuyOrderDirection, TimeInForce: FOKTimeInForce})
+		require.ErrorIs(t, err, ErrOrderKilled)
+		_, err = ob.Simulate(&Order{ID: 5, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection, PostOnly: RejectPostOnly})
+		require.ErrorIs(t, err, ErrPostOnly)
+		// no tick size to reprice by, the same as SubmitOrder
+		reprice := Order{ID: 5, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection, PostOnly: RepricePostOnly}
+		_, err = ob.Simulate(&reprice)
+		require.ErrorIs(t, err, ErrPostOnly)
+		_, err = ob.SubmitOrder(&reprice)
+		require.ErrorIs(t, err, ErrPostOnly)
+		_, err = ob.Simulate(&Order{ID: 5, Price: decimal.NewFromFloat(-1.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.ErrorIs(t, err, ErrBadPrice)
+	})
+}
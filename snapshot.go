@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+
+	rbtree "github.com/emirpasic/gods/trees/redblacktree"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	snapshotVersion = 1
+
+	// limits length prefixed fields of corrupted binary snapshots
+	maxSnapshotField = 1 << 16
+)
+
+// binary snapshots start with the magic, JSON ones with '{'
+var snapshotMagic = []byte("OBSN")
+
+type snapshot struct {
+	Version     int              `json:"version"`
+	LastTradeID TradeID          `json:"last_trade_id"`
+	LastPrice   decimal.Decimal  `json:"last_price"`
+	Sequence    uint64           `json:"seq"`
+	Buy         []*snapshotOrder `json:"buy"`
+	Sell        []*snapshotOrder `json:"sell"`
+	BuyStops    []*snapshotOrder `json:"buy_stops"`
+	SellStops   []*snapshotOrder `json:"sell_stops"`
+}
+
+// snapshotOrder keeps the displayed part of an iceberg the book does not take from callers
+type snapshotOrder struct {
+	*Order
+	Tip decimal.Decimal `json:"tip"`
+}
+
+func (s *snapshot) containers() []*[]*snapshotOrder {
+	return []*[]*snapshotOrder{&s.Buy, &s.Sell, &s.BuyStops, &s.SellStops}
+}
+
+// Snapshot writes the book state as versioned JSON, price levels best to worst and orders in queue priority
+func (ob *OrderBook) Snapshot(w io.Writer) error {
+	return json.NewEncoder(w).Encode(ob.snapshot())
+}
+
+// SnapshotBinary writes the same state as Snapshot in a compact binary format
+func (ob *OrderBook) SnapshotBinary(w io.Writer) error {
+	s := ob.snapshot()
+	bw := &binaryWriter{w: bufio.NewWriter(w)}
+
+	bw.write(snapshotMagic)
+	bw.uvarint(uint64(s.Version))
+	bw.uvarint(uint64(s.LastTradeID))
+	bw.decimal(s.LastPrice)
+	bw.uvarint(s.Sequence)
+	for _, orders := range s.containers() {
+		bw.uvarint(uint64(len(*orders)))
+		for _, o := range *orders {
+			bw.order(o)
+		}
+	}
+
+	if bw.err != nil {
+		return bw.err
+	}
+	return bw.w.Flush()
+}
+
+// Restore replaces the book state with a snapshot written by Snapshot or SnapshotBinary,
+// options the book has been created with are kept
+func (ob *OrderBook) Restore(r io.Reader) error {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(snapshotMagic))
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	var s *snapshot
+	if bytes.Equal(head, snapshotMagic) {
+		s, err = readBinarySnapshot(br)
+	} else {
+		s = &snapshot{}
+		err = json.NewDecoder(br).Decode(s)
+	}
+	if err != nil {
+		return err
+	}
+	if s.Version != snapshotVersion {
+		return ErrSnapshotVersion
+	}
+	return ob.restore(s)
+}
+
+func (ob *OrderBook) snapshot() *snapshot {
+	s := &snapshot{
+		Version:     snapshotVersion,
+		LastTradeID: ob.lastTradeID,
+		LastPrice:   ob.lastPrice,
+		Sequence:    ob.sequence,
+	}
+
+	s.Buy = ob.buy.orders(ob.buy.priceTree.Right(), nextMaxNode)
+	s.Sell = ob.sell.orders(ob.sell.priceTree.Left(), nextMinNode)
+	// buy stops trigger lowest first, sell stops highest first
+	s.BuyStops = ob.buyStops.orders(ob.buyStops.priceTree.Left(), nextMinNode)
+	s.SellStops = ob.sellStops.orders(ob.sellStops.priceTree.Right(), nextMaxNode)
+	return s
+}
+
+func (oc *OrderContainer) orders(node *rbtree.Node, next func(*rbtree.Node) *rbtree.Node) []*snapshotOrder {
+	orders := make([]*snapshotOrder, 0, len(oc.index))
+	for ; node != nil; node = next(node) {
+		queue := node.Value.(*OrderQueue)
+		for el := queue.orders.Front(); el != nil; el = el.Next() {
+			o := el.Value.(*Order)
+			orders = append(orders, &snapshotOrder{Order: o, Tip: o.tip})
+		}
+	}
+	return orders
+}
+
+func (ob *OrderBook) restore(s *snapshot) error {
+	containers := []*OrderContainer{newOrderContainer(), newOrderContainer(), newStopContainer(), newStopContainer()}
+	ids := make(map[OrderID]struct{})
+
+	for i, orders := range s.containers() {
+		for _, so := range *orders {
+			if so == nil || so.Order == nil {
+				return ErrBadSnapshot
+			}
+			o := *so.Order
+			if o.Amount.Sign() < 0 || o.Amount.IsZero() && !o.quote() {
+				return ErrBadSnapshot
+			}
+			if o.iceberg() != (so.Tip.Sign() > 0) || so.Tip.GreaterThan(decimal.Min(o.Display, o.Amount)) {
+				return ErrBadSnapshot
+			}
+			if _, ok := ids[o.ID]; ok {
+				return ErrBadSnapshot
+			}
+			ids[o.ID] = struct{}{}
+
+			containers[i].Add(&o)
+			containers[i].show(&o, so.Tip)
+		}
+		if err := containers[i].verify(); err != nil {
+			return err
+		}
+	}
+
+	for i, oc := range []*OrderContainer{ob.buy, ob.sell, ob.buyStops, ob.sellStops} {
+		containers[i].rounding = oc.rounding
+		containers[i].spec = oc.spec
+		if oc.changes != nil {
+			containers[i].changes = make(map[priceKey]levelChange)
+		}
+	}
+	ob.buy, ob.sell, ob.buyStops, ob.sellStops = containers[0], containers[1], containers[2], containers[3]
+	ob.lastTradeID = s.LastTradeID
+	ob.lastPrice = s.LastPrice
+	ob.sequence = s.Sequence
+	ob.invalidate()
+	return nil
+}
+
+// show puts back the displayed part of a restored iceberg which Add has reset to a full tip
+func (oc *OrderContainer) show(order *Order, tip decimal.Decimal) {
+	queue := oc.priceHash[oc.priceOf(order).String()]
+	diff := tip.Sub(order.tip)
+	order.tip = tip
+	queue.visible = queue.visible.Add(diff)
+	oc.visible = oc.visible.Add(diff)
+}
+
+// verify checks container volumes against its price levels
+func (oc *OrderContainer) verify() error {
+	volume, visible := decimal.Zero, decimal.Zero
+	for _, v := range oc.priceTree.Values() {
+		queue := v.(*OrderQueue)
+		volume = volume.Add(queue.TotalVolume())
+		visible = visible.Add(queue.Volume())
+	}
+	if !volume.Equal(oc.TotalVolume()) || !visible.Equal(oc.Volume()) {
+		return ErrBadSnapshot
+	}
+	return nil
+}
+
+type binaryWriter struct {
+	w   *bufio.Writer
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (bw *binaryWriter) write(p []byte) {
+	if bw.err == nil {
+		_, bw.err = bw.w.Write(p)
+	}
+}
+
+func (bw *binaryWriter) uvarint(v uint64) {
+	bw.write(bw.buf[:binary.PutUvarint(bw.buf[:], v)])
+}
+
+func (bw *binaryWriter) varint(v int64) {
+	bw.write(bw.buf[:binary.PutVarint(bw.buf[:], v)])
+}
+
+func (bw *binaryWriter) bytes(p []byte) {
+	bw.uvarint(uint64(len(p)))
+	bw.write(p)
+}
+
+// decimal is written as exponent and signed coefficient
+func (bw *binaryWriter) decimal(d decimal.Decimal) {
+	bw.varint(int64(d.Exponent()))
+	coef := d.Coefficient()
+	bw.varint(int64(coef.Sign()))
+	bw.bytes(coef.Bytes())
+}
+
+func (bw *binaryWriter) order(o *snapshotOrder) {
+	for _, d := range []decimal.Decimal{o.Amount, o.Price, o.StopPrice, o.Display, o.Tip, o.Notional, o.Protection, o.MaxSlippage} {
+		bw.decimal(d)
+	}
+	bw.uvarint(uint64(o.SlippageType))
+	bw.varint(int64(o.Timestamp))
+	bw.varint(int64(o.ExpireAt))
+	bw.uvarint(uint64(o.ID))
+	bw.uvarint(uint64(o.Type))
+	bw.uvarint(uint64(o.Dir))
+	bw.uvarint(uint64(o.TimeInForce))
+	bw.uvarint(uint64(o.PostOnly))
+	bw.bytes([]byte(o.Owner))
+	bw.uvarint(uint64(o.STP))
+}
+
+type binaryReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func readBinarySnapshot(r *bufio.Reader) (*snapshot, error) {
+	br := &binaryReader{r: r}
+	br.bytesN(len(snapshotMagic))
+
+	s := &snapshot{
+		Version:     int(br.uvarint()),
+		LastTradeID: TradeID(br.uvarint()),
+		LastPrice:   br.decimal(),
+		Sequence:    br.uvarint(),
+	}
+	if br.err == nil && s.Version != snapshotVersion {
+		return nil, ErrSnapshotVersion
+	}
+
+	for _, orders := range s.containers() {
+		n := br.uvarint()
+		for i := uint64(0); i < n && br.err == nil; i++ {
+			*orders = append(*orders, br.order())
+		}
+	}
+	if br.err != nil {
+		return nil, br.err
+	}
+	return s, nil
+}
+
+func (br *binaryReader) fail(err error) {
+	if br.err == nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrBadSnapshot
+		}
+		br.err = err
+	}
+}
+
+func (br *binaryReader) uvarint() uint64 {
+	if br.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(br.r)
+	br.fail(err)
+	return v
+}
+
+func (br *binaryReader) varint() int64 {
+	if br.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(br.r)
+	br.fail(err)
+	return v
+}
+
+func (br *binaryReader) bytesN(n int) []byte {
+	if br.err != nil {
+		return nil
+	}
+	p := make([]byte, n)
+	_, err := io.ReadFull(br.r, p)
+	br.fail(err)
+	return p
+}
+
+func (br *binaryReader) bytes() []byte {
+	n := br.uvarint()
+	if n > maxSnapshotField {
+		br.fail(ErrBadSnapshot)
+	}
+	return br.bytesN(int(n))
+}
+
+func (br *binaryReader) decimal() decimal.Decimal {
+	exp := br.varint()
+	sign := br.varint()
+	coef := new(big.Int).SetBytes(br.bytes())
+	if sign < 0 {
+		coef.Neg(coef)
+	}
+	return decimal.NewFromBigInt(coef, int32(exp))
+}
+
+func (br *binaryReader) order() *snapshotOrder {
+	o := &snapshotOrder{Order: &Order{}}
+	for _, d := range []*decimal.Decimal{&o.Amount, &o.Price, &o.StopPrice, &o.Display, &o.Tip, &o.Notional, &o.Protection, &o.MaxSlippage} {
+		*d = br.decimal()
+	}
+	o.SlippageType = SlippageType(br.uvarint())
+	o.Timestamp = MillisecondTimestamp(br.varint())
+	o.ExpireAt = MillisecondTimestamp(br.varint())
+	o.ID = OrderID(br.uvarint())
+	o.Type = OrderType(br.uvarint())
+	o.Dir = OrderDirection(br.uvarint())
+	o.TimeInForce = TimeInForce(br.uvarint())
+	o.PostOnly = PostOnly(br.uvarint())
+	o.Owner = string(br.bytes())
+	o.STP = STPMode(br.uvarint())
+	return o
+}
+
+var (
+	ErrBadSnapshot     = errors.New("bad snapshot")
+	ErrSnapshotVersion = errors.New("unsupported snapshot version")
+)
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	init := func(t *testing.T) *OrderBook {
+		ob := NewOrderBook(WithClock(NewManualClock(1_000)))
+		orders := []Order{
+			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection, Owner: "a"},
+			{ID: 2, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(50.0), Type: LimitOrderType, Dir: BuyOrderDirection, TimeInForce: GTDTimeInForce, ExpireAt: 5_000},
+			{ID: 3, Price: decimal.RequireFromString("9.25"), Amount: decimal.NewFromFloat(30.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+			{ID: 4, Price: decimal.NewFromFloat(12.0), Amount: decimal.NewFromFloat(100.0), Display: decimal.NewFromFloat(20.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 5, Price: decimal.NewFromFloat(12.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: SellOrderDirection, Owner: "b", STP: CancelNewestSTPMode},
+			{ID: 6, Price: decimal.NewFromFloat(13.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			// takes part of the iceberg tip
+			{ID: 7, Price: decimal.NewFromFloat(12.0), Amount: decimal.NewFromFloat(15.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+			{ID: 8, Price: decimal.NewFromFloat(14.0), StopPrice: decimal.NewFromFloat(12.5), Amount: decimal.NewFromFloat(5.0), Type: StopLimitOrderType, Dir: BuyOrderDirection},
+			{ID: 9, Price: decimal.NewFromFloat(1.0), StopPrice: decimal.NewFromFloat(9.0), Amount: decimal.NewFromFloat(5.0), Type: StopOrderType, Dir: SellOrderDirection},
+		}
+		for _, v := range orders {
+			submitOrder(t, ob, v)
+		}
+		return ob
+	}
+
+	walk := func(ob *OrderBook, dir OrderDirection) []BookOrder {
+		orders := make([]BookOrder, 0)
+		ob.WalkOrders(dir, func(o BookOrder) bool {
+			orders = append(orders, o)
+			return true
+		})
+		return orders
+	}
+
+	// decimals are compared by value, JSON snapshots do not keep their exponents
+	requireJSON := func(t *testing.T, expected, actual any) {
+		e, err := json.Marshal(expected)
+		require.NoError(t, err)
+		a, err := json.Marshal(actual)
+		require.NoError(t, err)
+		require.JSONEq(t, string(e), string(a))
+	}
+
+	requireSame := func(t *testing.T, expected, actual *OrderBook) {
+		require.Equal(t, expected.Checksum(), actual.Checksum())
+		require.Equal(t, expected.Depth(0).Sequence, actual.Depth(0).Sequence)
+		requireJSON(t, walk(expected, BuyOrderDirection), walk(actual, BuyOrderDirection))
+		requireJSON(t, walk(expected, SellOrderDirection), walk(actual, SellOrderDirection))
+		require.Equal(t, expected.lastTradeID, actual.lastTradeID)
+		require.True(t, expected.lastPrice.Equal(actual.lastPrice))
+
+		containers := []*OrderContainer{expected.buy, expected.sell, expected.buyStops, expected.sellStops}
+		for i, oc := range []*OrderContainer{actual.buy, actual.sell, actual.buyStops, actual.sellStops} {
+			require.NoError(t, oc.verify())
+			require.True(t, containers[i].TotalVolume().Equal(oc.TotalVolume()))
+			require.Equal(t, len(containers[i].index), len(oc.index))
+			require.Equal(t, len(containers[i].expiring), len(oc.expiring))
+		}
+
+		// both books keep matching the same way
+		o := Order{ID: 10, Price: decimal.NewFromFloat(1.0), Amount: decimal.NewFromFloat(200.0), Type: MarketOrderType, Dir: SellOrderDirection}
+		requireJSON(t, submitTrades(t, expected, o), submitTrades(t, actual, o))
+		o = Order{ID: 11, Price: decimal.NewFromFloat(15.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection}
+		requireJSON(t, submitTrades(t, expected, o), submitTrades(t, actual, o))
+	}
+
+	t.Run("json", func(t *testing.T) {
+		ob := init(t)
+		var buf bytes.Buffer
+		require.NoError(t, ob.Snapshot(&buf))
+		require.True(t, strings.HasPrefix(buf.String(), `{"version":1`))
+
+		restored := NewOrderBook(WithClock(NewManualClock(1_000)))
+		require.NoError(t, restored.Restore(&buf))
+		requireSame(t, ob, restored)
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		ob := init(t)
+		var buf, js bytes.Buffer
+		require.NoError(t, ob.SnapshotBinary(&buf))
+		require.NoError(t, ob.Snapshot(&js))
+		require.Less(t, buf.Len(), js.Len())
+
+		restored := NewOrderBook(WithClock(NewManualClock(1_000)))
+		require.NoError(t, restored.Restore(&buf))
+		requireSame(t, ob, restored)
+	})
+
+	t.Run("restore replaces state", func(t *testing.T) {
+		ob := init(t)
+		var buf bytes.Buffer
+		require.NoError(t, NewOrderBook().SnapshotBinary(&buf))
+		require.NoError(t, ob.Restore(&buf))
+
+		require.Equal(t, 0, len(ob.Depth(0).Bids))
+		require.Equal(t, 0, len(ob.Depth(0).Asks))
+		require.Equal(t, 0, len(ob.buyStops.index))
+		require.Equal(t, TradeID(0), ob.lastTradeID)
+	})
+
+	t.Run("quote stop orders", func(t *testing.T) {
+		ob := init(t)
+		submitOrder(t, ob, Order{ID: 20, Price: decimal.NewFromFloat(1.0), StopPrice: decimal.NewFromFloat(14.0), Notional: decimal.NewFromFloat(100.0), Type: StopOrderType, Dir: BuyOrderDirection})
+
+		var buf bytes.Buffer
+		require.NoError(t, ob.SnapshotBinary(&buf))
+		restored := NewOrderBook()
+		require.NoError(t, restored.Restore(&buf))
+		_, _, ok := restored.findStop(20)
+		require.True(t, ok)
+	})
+
+	t.Run("bad snapshots", func(t *testing.T) {
+		ob := init(t)
+		var buf bytes.Buffer
+		require.NoError(t, ob.SnapshotBinary(&buf))
+
+		require.ErrorIs(t, NewOrderBook().Restore(bytes.NewReader(buf.Bytes()[:buf.Len()/2])), ErrBadSnapshot)
+		require.ErrorIs(t, NewOrderBook().Restore(strings.NewReader(`{"version":2}`)), ErrSnapshotVersion)
+		require.ErrorIs(t, NewOrderBook().Restore(strings.NewReader(`{"version":1,"buy":[{"id":1,"amount":"1","price":"1"}],"sell":[{"id":1,"amount":"1","price":"2"}]}`)), ErrBadSnapshot)
+		require.ErrorIs(t, NewOrderBook().Restore(strings.NewReader(`{"version":1,"sell":[{"id":1,"amount":"10","price":"2","display":"5","tip":"100"}]}`)), ErrBadSnapshot)
+
+		// failed restore leaves the book alone
+		before := ob.Checksum()
+		require.Error(t, ob.Restore(strings.NewReader(`{"version":1,"buy":[{"id":1,"amount":"-1","price":"1"}]}`)))
+		require.Equal(t, before, ob.Checksum())
+	})
+}
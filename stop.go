@@ -0,0 +1,101 @@
+package main
+
+import (
+	"container/list"
+)
+
+func (ob *OrderBook) findStop(id OrderID) (*OrderContainer, *list.Element, bool) {
+	if el, ok := ob.buyStops.find(id); ok {
+		return ob.buyStops, el, true
+	}
+	if el, ok := ob.sellStops.find(id); ok {
+		return ob.sellStops, el, true
+	}
+	return nil, nil, false
+}
+
+func (ob *OrderBook) stopReached(order *Order) bool {
+	if ob.lastPrice.IsZero() {
+		return false
+	}
+	if order.Dir == BuyOrderDirection {
+		return ob.lastPrice.GreaterThanOrEqual(order.StopPrice)
+	}
+	return ob.lastPrice.LessThanOrEqual(order.StopPrice)
+}
+
+// stop orders are held off-book until the last trade price crosses Order.StopPrice
+func (ob *OrderBook) addStopOrder(order *Order) (Transaction, error) {
+	if order.StopPrice.Sign() <= 0 {
+		return Transaction{}, ErrBadStopPrice
+	}
+	if ob.stopReached(order) {
+		return Transaction{}, ErrStopTrigger
+	}
+
+	stops := ob.sellStops
+	if order.Dir == BuyOrderDirection {
+		stops = ob.buyStops
+	}
+	return ob.newTransaction(nil, nil, func() {
+		stops.Add(order)
+	}), nil
+}
+
+// triggered returns the next stop order to activate: buy stops with the lowest stop price first,
+// then sell stops with the highest one, each level in time priority
+func (ob *OrderBook) triggered() (*OrderContainer, *list.Element) {
+	if ob.lastPrice.IsZero() {
+		return nil, nil
+	}
+
+	if node := ob.buyStops.priceTree.Left(); node != nil {
+		queue := node.Value.(*OrderQueue)
+		if ob.lastPrice.GreaterThanOrEqual(queue.Price()) {
+			return ob.buyStops, queue.orders.Front()
+		}
+	}
+	if node := ob.sellStops.priceTree.Right(); node != nil {
+		queue := node.Value.(*OrderQueue)
+		if ob.lastPrice.LessThanOrEqual(queue.Price()) {
+			return ob.sellStops, queue.orders.Front()
+		}
+	}
+	return nil, nil
+}
+
+// activateStops submits triggered stop orders one by one against the current book,
+// every activation may move the last price and trigger further stops
+func (ob *OrderBook) activateStops(tr *Transaction) {
+	for {
+		stops, el := ob.triggered()
+		if el == nil {
+			break
+		}
+
+		order := el.Value.(*Order)
+		stops.removeOrder(el)
+
+		if order.Type == StopOrderType {
+			order.Type = MarketOrderType
+		} else {
+			order.Type = LimitOrderType
+		}
+
+		activated, err := ob.SubmitOrder(order)
+		if err != nil {
+			// rejected on activation, e.g. fill or kill, the whole order is cancelled
+			tr.orders = append(tr.orders, order)
+			tr.cancelled = append(tr.cancelled, cancellation(order))
+			continue
+		}
+
+		activated.finalize()
+		ob.settle(activated.trades)
+
+		tr.orders = append(tr.orders, activated.orders...)
+		tr.trades = append(tr.trades, activated.trades...)
+		tr.prevented = append(tr.prevented, activated.prevented...)
+		tr.cancelled = append(tr.cancelled, activated.cancelled...)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStopOrders(t *testing.T) {
+	init := func(t *testing.T) *OrderBook {
+		ob := NewOrderBook()
+		orders := []Order{
+			{ID: 1, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 2, Price: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 3, Price: decimal.NewFromFloat(12.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: SellOrderDirection},
+			{ID: 4, Price: decimal.NewFromFloat(9.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+			{ID: 5, Price: decimal.NewFromFloat(8.0), Amount: decimal.NewFromFloat(100.0), Type: LimitOrderType, Dir: BuyOrderDirection},
+		}
+		for _, v := range orders {
+			submitOrder(t, ob, v)
+		}
+		return ob
+	}
+
+	t.Run("rest until triggered", func(t *testing.T) {
+		ob := init(t)
+		stop := Order{ID: 6, Price: decimal.NewFromFloat(11.0), StopPrice: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(50.0), Type: StopOrderType, Dir: BuyOrderDirection}
+		require.Equal(t, 0, len(submitTrades(t, ob, stop)))
+		require.Equal(t, 1, len(getQueues(ob.buyStops)))
+
+		// trades at 10 only, stop stays untouched
+		trades := submitTrades(t, ob, Order{ID: 7, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(50.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.Equal(t, 1, len(trades))
+		require.Equal(t, 1, len(getQueues(ob.buyStops)))
+
+		// trades at 11 trigger the stop within the same transaction
+		tr, err := ob.SubmitOrder(&Order{ID: 8, Price: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(60.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.NoError(t, err)
+		trades, err = tr.Commit()
+		require.NoError(t, err)
+
+		require.Equal(t, 3, len(trades))
+		require.Equal(t, OrderID(8), trades[0].Taker)
+		require.Equal(t, OrderID(1), trades[0].Maker)
+		require.Equal(t, OrderID(8), trades[1].Taker)
+		require.Equal(t, OrderID(2), trades[1].Maker)
+		require.True(t, decimal.NewFromFloat(10.0).Equal(trades[1].Amount))
+		require.Equal(t, OrderID(6), trades[2].Taker)
+		require.Equal(t, OrderID(2), trades[2].Maker)
+		require.True(t, decimal.NewFromFloat(50.0).Equal(trades[2].Amount))
+		require.Equal(t, []TradeID{2, 3, 4}, []TradeID{trades[0].ID, trades[1].ID, trades[2].ID})
+
+		require.Equal(t, 0, len(getQueues(ob.buyStops)))
+		require.True(t, decimal.NewFromFloat(140.0).Equal(ob.sell.Volume()))
+	})
+
+	t.Run("stop limit rests after trigger", func(t *testing.T) {
+		ob := init(t)
+		submitOrder(t, ob, Order{ID: 6, Price: decimal.NewFromFloat(7.5), StopPrice: decimal.NewFromFloat(9.0), Amount: decimal.NewFromFloat(300.0), Type: StopLimitOrderType, Dir: SellOrderDirection})
+
+		trades := submitTrades(t, ob, Order{ID: 7, Price: decimal.NewFromFloat(9.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: SellOrderDirection})
+		require.Equal(t, 3, len(trades))
+		require.Equal(t, OrderID(6), trades[1].Taker)
+		require.Equal(t, OrderID(4), trades[1].Maker)
+		require.True(t, decimal.NewFromFloat(90.0).Equal(trades[1].Amount))
+		require.Equal(t, OrderID(5), trades[2].Maker)
+
+		require.Equal(t, 0, len(getQueues(ob.buy)))
+		queues := getQueues(ob.sell)
+		require.True(t, decimal.NewFromFloat(7.5).Equal(queues[0].Price()))
+		require.True(t, decimal.NewFromFloat(110.0).Equal(queues[0].Volume()))
+	})
+
+	t.Run("triggered iceberg shows a tip of the rest", func(t *testing.T) {
+		ob := init(t)
+		submitOrder(t, ob, Order{ID: 6, Price: decimal.NewFromFloat(7.5), StopPrice: decimal.NewFromFloat(9.0), Amount: decimal.NewFromFloat(192.0), Display: decimal.NewFromFloat(5.0), Type: StopLimitOrderType, Dir: SellOrderDirection})
+
+		trades := submitTrades(t, ob, Order{ID: 7, Price: decimal.NewFromFloat(9.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: SellOrderDirection})
+		require.Equal(t, 3, len(trades))
+
+		queues := getQueues(ob.sell)
+		require.True(t, decimal.NewFromFloat(7.5).Equal(queues[0].Price()))
+		require.True(t, decimal.NewFromFloat(2.0).Equal(queues[0].Volume()))
+		require.True(t, decimal.NewFromFloat(2.0).Equal(queues[0].TotalVolume()))
+
+		trades = submitTrades(t, ob, Order{ID: 8, Price: decimal.NewFromFloat(7.5), Amount: decimal.NewFromFloat(5.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.Equal(t, 1, len(trades))
+		require.True(t, decimal.NewFromFloat(2.0).Equal(trades[0].Amount))
+		require.Equal(t, len(queues)-1, len(getQueues(ob.sell)))
+	})
+
+	t.Run("rejected on activation", func(t *testing.T) {
+		ob := init(t)
+		submitOrder(t, ob, Order{ID: 6, Price: decimal.NewFromFloat(9.0), StopPrice: decimal.NewFromFloat(9.0), Amount: decimal.NewFromFloat(300.0), Type: StopLimitOrderType, Dir: SellOrderDirection, TimeInForce: FOKTimeInForce})
+
+		tr, err := ob.SubmitOrder(&Order{ID: 7, Price: decimal.NewFromFloat(9.0), Amount: decimal.NewFromFloat(10.0), Type: LimitOrderType, Dir: SellOrderDirection})
+		require.NoError(t, err)
+		trades, err := tr.Commit()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(trades))
+
+		require.Equal(t, []Cancellation{{Amount: decimal.NewFromFloat(300.0), ID: 6}}, tr.Cancelled())
+		require.Equal(t, 0, len(getQueues(ob.sellStops)))
+		require.True(t, decimal.NewFromFloat(90.0).Equal(ob.buy.index[4].Value.(*Order).Amount))
+	})
+
+	t.Run("cascade", func(t *testing.T) {
+		ob := init(t)
+		stops := []Order{
+			{ID: 6, Price: decimal.NewFromFloat(12.0), StopPrice: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(100.0), Type: StopLimitOrderType, Dir: BuyOrderDirection},
+			{ID: 7, Price: decimal.NewFromFloat(12.0), StopPrice: decimal.NewFromFloat(12.0), Amount: decimal.NewFromFloat(50.0), Type: StopOrderType, Dir: BuyOrderDirection},
+			{ID: 8, Price: decimal.NewFromFloat(12.0), StopPrice: decimal.NewFromFloat(10.5), Amount: decimal.NewFromFloat(10.0), Type: StopOrderType, Dir: BuyOrderDirection},
+		}
+		for _, v := range stops {
+			submitOrder(t, ob, v)
+		}
+
+		trades := submitTrades(t, ob, Order{ID: 9, Price: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(110.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+
+		takers := make([]OrderID, 0)
+		for _, tr := range trades {
+			takers = append(takers, tr.Taker)
+		}
+		// 8 (10.5) activates before 6 (11.0), the fills of 6 at 12 reach 7
+		require.Equal(t, []OrderID{9, 9, 8, 6, 6, 7}, takers)
+		require.Equal(t, 0, len(getQueues(ob.buyStops)))
+		require.True(t, decimal.NewFromFloat(30.0).Equal(ob.sell.Volume()))
+	})
+
+	t.Run("rollback does not trigger", func(t *testing.T) {
+		ob := init(t)
+		submitOrder(t, ob, Order{ID: 6, Price: decimal.NewFromFloat(11.0), StopPrice: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(50.0), Type: StopOrderType, Dir: BuyOrderDirection})
+
+		tr, err := ob.SubmitOrder(&Order{ID: 7, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(50.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		require.NoError(t, err)
+		require.NoError(t, tr.Rollback())
+
+		require.Equal(t, 1, len(getQueues(ob.buyStops)))
+		require.True(t, decimal.NewFromFloat(300.0).Equal(ob.sell.Volume()))
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		ob := init(t)
+		submitOrder(t, ob, Order{ID: 6, Price: decimal.NewFromFloat(11.0), StopPrice: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(50.0), Type: StopOrderType, Dir: BuyOrderDirection})
+
+		tr, err := ob.CancelOrder(6)
+		require.NoError(t, err)
+		_, err = tr.Commit()
+		require.NoError(t, err)
+
+		require.Equal(t, 0, len(getQueues(ob.buyStops)))
+		require.Equal(t, 1, len(submitTrades(t, ob, Order{ID: 7, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(50.0), Type: LimitOrderType, Dir: BuyOrderDirection})))
+	})
+
+	t.Run("bad stop price", func(t *testing.T) {
+		ob := init(t)
+		_, err := ob.SubmitOrder(&Order{ID: 6, Price: decimal.NewFromFloat(11.0), Amount: decimal.NewFromFloat(50.0), Type: StopOrderType, Dir: BuyOrderDirection})
+		require.ErrorIs(t, err, ErrBadStopPrice)
+
+		submitOrder(t, ob, Order{ID: 7, Price: decimal.NewFromFloat(10.0), Amount: decimal.NewFromFloat(50.0), Type: LimitOrderType, Dir: BuyOrderDirection})
+		_, err = ob.SubmitOrder(&Order{ID: 6, Price: decimal.NewFromFloat(11.0), StopPrice: decimal.NewFromFloat(9.5), Amount: decimal.NewFromFloat(50.0), Type: StopOrderType, Dir: BuyOrderDirection})
+		require.ErrorIs(t, err, ErrStopTrigger)
+	})
+}